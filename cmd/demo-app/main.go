@@ -7,19 +7,29 @@ import (
 	"github.com/integrity-sum/internal/core/services"
 	"github.com/integrity-sum/internal/repositories"
 	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/hasher"
 	logConfig "github.com/integrity-sum/pkg/logger"
+	"github.com/integrity-sum/pkg/pathfilter"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 )
 
 var dirPath string
 var algorithm string
+var symlinks string
+var include string
+var exclude string
 var doHelp bool
 
 // Initializes the binding of the flag to a variable that must run before the main() function
 func init() {
 	flag.StringVar(&dirPath, "d", "", "a specific file or directory")
-	flag.StringVar(&algorithm, "a", "SHA256", "algorithm MD5, SHA1, SHA224, SHA256, SHA384, SHA512, default: SHA256")
+	flag.StringVar(&algorithm, "a", hasher.DefaultAlgorithm, "algorithm "+strings.Join(hasher.Algorithms(), ", ")+", default: "+hasher.DefaultAlgorithm)
+	flag.StringVar(&symlinks, "symlinks", api.DefaultSymlinks, "symlinks whose target is hashed: record, within the directory or anywhere, default: "+api.DefaultSymlinks)
+	flag.StringVar(&include, "include", "", "comma-separated gitignore-style patterns of the files to hash")
+	flag.StringVar(&exclude, "exclude", "", "comma-separated gitignore-style patterns of the files and directories to skip")
 	flag.BoolVar(&doHelp, "h", false, "help")
 }
 
@@ -32,16 +42,9 @@ func main() {
 		logger.Fatal("Error during loading from config file", err)
 	}
 
-	// Install context and signal
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-
-	defer func() {
-		signal.Stop(sig)
-		cancel()
-	}()
+	// Install context cancelled by a signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	switch {
 	case doHelp:
@@ -54,34 +57,49 @@ func main() {
 		}
 		flag.Usage()
 	case len(dirPath) > 0:
-		// Initialize repository
-		repository := repositories.NewAppRepository(logger)
+		filter, err := pathfilter.New(pathfilter.Split(include), pathfilter.Split(exclude))
+		if err != nil {
+			logger.Fatal("Invalid path patterns ", err)
+		}
 
-		// Initialize service
-		service := services.NewAppService(repository, algorithm, logger)
+		algorithm = strings.ToUpper(algorithm)
+		if _, err := hasher.NewHashSum(algorithm); err != nil {
+			logger.Fatal("Invalid algorithm ", err)
+		}
+
+		policy, err := api.ParseSymlinks(symlinks)
 		if err != nil {
-			logger.Fatalf("can't init service: %s", err)
+			logger.Fatal("Invalid symlink policy ", err)
 		}
 
-		jobs := make(chan string)
+		// Initialize repository
+		repository, err := repositories.NewAppRepository(logger)
+		if err != nil {
+			logger.Fatalf("can't init repository: %s", err)
+		}
+		defer func() {
+			if err := repository.Close(); err != nil {
+				logger.Errorf("error while closing repository %s", err)
+			}
+		}()
+
+		// Initialize service
+		service := services.NewAppService(repository, algorithm, "", policy, logger)
+
+		jobs := make(chan api.Job)
 		results := make(chan *api.HashData)
 
-		go service.WorkerPool(jobs, results)
-		go api.SearchFilePath(dirPath, jobs, logger)
-		for {
-			select {
-			case hashData, ok := <-results:
-				if !ok {
-					return
-				}
-				fmt.Printf("%s %s\n", hashData.Hash, hashData.FileName)
-			case <-sig:
-				fmt.Println("exit program")
-				return
-			case <-ctx.Done():
-				fmt.Println("program termination after receiving a signal")
-				return
+		go service.WorkerPool(ctx, jobs, results)
+		go api.SearchFilePath(ctx, dirPath, filter, api.SymlinkPolicy{Mode: policy}, jobs, logger)
+		for hashData := range results {
+			// Directories, special files and symlinks which are not followed have no content
+			if hashData.Hash == "" {
+				continue
 			}
+			fmt.Printf("%s %s\n", hashData.Hash, hashData.FileName)
+		}
+		if ctx.Err() != nil {
+			fmt.Println("program termination after receiving a signal")
 		}
 	default:
 		logger.Println("use the -h flag on the command line to see all the flags in this app")
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/integrity-sum/internal/configs"
+	"github.com/integrity-sum/internal/core/services"
+	"github.com/integrity-sum/internal/repositories"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/hasher"
+	"github.com/integrity-sum/pkg/image"
+	logConfig "github.com/integrity-sum/pkg/logger"
+	"github.com/integrity-sum/pkg/pathfilter"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+var imagePath string
+var mountPath string
+var algorithm string
+var imageName string
+var digest string
+var platform string
+var symlinks string
+var include string
+var exclude string
+var doHelp bool
+
+// Initializes the binding of the flag to a variable that must run before the main() function
+func init() {
+	flag.StringVar(&imagePath, "i", "", "path to an OCI image layout directory or a docker save tarball")
+	flag.StringVar(&mountPath, "m", "", "comma-separated paths inside the image to compute the baseline for, the same as MOUNT_PATH in the ConfigMap")
+	flag.StringVar(&algorithm, "a", hasher.DefaultAlgorithm, "algorithm "+strings.Join(hasher.Algorithms(), ", ")+", default: "+hasher.DefaultAlgorithm)
+	flag.StringVar(&imageName, "n", "", "name of the image, for example nginx:1.23, saved for reference")
+	flag.StringVar(&digest, "digest", "", "digest to store the baseline under, by default every digest read from the image")
+	flag.StringVar(&platform, "platform", "linux/"+runtime.GOARCH, "platform to select from a multi-platform image")
+	flag.StringVar(&symlinks, "symlinks", api.DefaultSymlinks, "symlinks whose target is hashed: record, within the mount path or anywhere, the same as SYMLINKS of the sidecar")
+	flag.StringVar(&include, "include", "", "comma-separated gitignore-style patterns of the files to hash, the same as INCLUDE in the ConfigMap")
+	flag.StringVar(&exclude, "exclude", "", "comma-separated gitignore-style patterns of the files to skip, the same as EXCLUDE in the ConfigMap")
+	flag.BoolVar(&doHelp, "h", false, "help")
+}
+
+func main() {
+	flag.Parse()
+
+	if doHelp || imagePath == "" || mountPath == "" {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\nComputes the baseline of the files in the image and saves it to the database\n", os.Args[0])
+		flag.PrintDefaults()
+		if !doHelp {
+			os.Exit(2)
+		}
+		return
+	}
+
+	// Load values from .env into the system
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using the environment")
+	}
+
+	// Checking database connection values
+	configs.ValidateDBConnectionValues()
+
+	// Initialize config for logger
+	logger, err := logConfig.LoadConfig()
+	if err != nil {
+		logger.Fatal("Error during loading from config file", err)
+	}
+
+	filter, err := pathfilter.New(pathfilter.Split(include), pathfilter.Split(exclude))
+	if err != nil {
+		logger.Fatalf("invalid path patterns: %s", err)
+	}
+
+	algorithm = strings.ToUpper(algorithm)
+	if _, err := hasher.NewHashSum(algorithm); err != nil {
+		logger.Fatalf("invalid algorithm: %s", err)
+	}
+	policy, err := api.ParseSymlinks(symlinks)
+	if err != nil {
+		logger.Fatalf("invalid symlink policy: %s", err)
+	}
+	result, err := image.Baseline(imagePath, strings.Split(mountPath, ","), algorithm, platform, policy, filter)
+	if err != nil {
+		logger.Fatalf("can't compute baseline of the image %s: %s", imagePath, err)
+	}
+	digests := result.Digests
+	if digest != "" {
+		digests = []string{digest}
+	}
+	if len(result.HashData) == 0 {
+		logger.Fatalf("there are no files under %s in the image %s", mountPath, imagePath)
+	}
+
+	if err := saveBaseline(logger, digests, result.HashData); err != nil {
+		logger.Fatalf("can't save baseline of the image: %s", err)
+	}
+	logger.Infof("Saved baseline of %d files for the image %s", len(result.HashData), strings.Join(digests, ", "))
+}
+
+// saveBaseline saves the baseline under every digest of the image, so the sidecar finds it by the digest the runtime reports
+func saveBaseline(logger *logrus.Logger, digests []string, allHashData []*api.HashData) error {
+	repository, err := repositories.NewAppRepository(logger)
+	if err != nil {
+		return fmt.Errorf("can't init repository: %w", err)
+	}
+	defer func() {
+		if err := repository.Close(); err != nil {
+			logger.Errorf("error while closing repository %s", err)
+		}
+	}()
+
+	service := services.NewHashService(repository.IHashRepository, algorithm, logger)
+	for _, digest := range digests {
+		if err := service.SaveImageBaseline(digest, imageName, allHashData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/integrity-sum/internal/initialize"
 	"github.com/joho/godotenv"
@@ -27,15 +28,13 @@ func main() {
 		logger.Fatal("Error during loading from config file", err)
 	}
 
-	// Handling shutdown signals
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer func() {
-		signal.Stop(sig)
-		cancel()
-	}()
+	// Handling shutdown signals, Kubernetes stops containers with SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 	// Initialize program
-	initialize.Initialize(ctx, logger, sig)
+	err = initialize.Initialize(ctx, logger)
+	stop()
+	if err != nil {
+		logger.Fatalf("integrity-sum stopped: %s", err)
+	}
 }
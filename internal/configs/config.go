@@ -1,10 +1,14 @@
 package configs
 
 import (
+	"errors"
 	"log"
 	"os"
 )
 
+// ErrInvalidConfig marks the errors which retrying can't fix, the sidecar exits on them
+var ErrInvalidConfig = errors.New("invalid configuration")
+
 func ValidateDBConnectionValues() {
 	DbDriver, ok := os.LookupEnv("DB_DRIVER")
 	if !ok {
@@ -12,6 +16,14 @@ func ValidateDBConnectionValues() {
 		log.Printf("DB_DRIVER was not set. setting by default: %s", DbDriver)
 	}
 
+	// The embedded database only needs a path to the file on a volume
+	if DbDriver == "bolt" {
+		if _, ok := os.LookupEnv("DB_PATH"); !ok {
+			log.Printf("DB_PATH was not set. the default database file will be used")
+		}
+		return
+	}
+
 	DbHost, ok := os.LookupEnv("DB_HOST")
 	if !ok {
 		DbHost = "localhost"
@@ -1,6 +1,13 @@
 package models
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/pathfilter"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -9,10 +16,113 @@ type HashDataFromDB struct {
 	Hash           string
 	FileName       string
 	FullFilePath   string
+	MountPath      string
 	Algorithm      string
 	ImageContainer string
+	ImageDigest    string
 	NamePod        string
 	NameDeployment string
+	api.Metadata
+}
+
+// ChangeType describes the kind of difference found between a file and its stored hash sum
+type ChangeType string
+
+const (
+	ChangeAdded        ChangeType = "added"
+	ChangeDeleted      ChangeType = "deleted"
+	ChangeModified     ChangeType = "modified"
+	ChangeImageChanged ChangeType = "image"
+)
+
+// Attributes of a file compared with the baseline
+const (
+	AttributeContent    = "content"
+	AttributeType       = "type"
+	AttributeMode       = "mode"
+	AttributeUID        = "uid"
+	AttributeGID        = "gid"
+	AttributeSize       = "size"
+	AttributeLinkTarget = "link_target"
+)
+
+// AttributeChange is an attribute of a modified file which differs from the baseline
+type AttributeChange struct {
+	Attribute string
+	Old       string
+	New       string
+}
+
+// String formats the change as attribute old -> new
+func (c AttributeChange) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.Attribute, c.Old, c.New)
+}
+
+// FileChange describes a single difference between the current data and the data in the database
+type FileChange struct {
+	Type         ChangeType
+	FileName     string
+	FullFilePath string
+	OldHash      string
+	NewHash      string
+	OldImage     string
+	NewImage     string
+	// Reasons lists the attributes which differ for a modified file
+	Reasons []AttributeChange
+}
+
+// ReasonsString joins the reasons of the change, like "mode 0755 -> 4755, uid 0 -> 1000"
+func (c *FileChange) ReasonsString() string {
+	reasons := make([]string, 0, len(c.Reasons))
+	for _, reason := range c.Reasons {
+		reasons = append(reasons, reason.String())
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// DiffReport contains all differences found during one integrity check
+type DiffReport struct {
+	Changes []*FileChange
+}
+
+// IsChanged reports whether at least one difference was found
+func (r *DiffReport) IsChanged() bool {
+	return r != nil && len(r.Changes) > 0
+}
+
+// Count returns the number of differences of the given type
+func (r *DiffReport) Count(changeType ChangeType) int {
+	if r == nil {
+		return 0
+	}
+	count := 0
+	for _, change := range r.Changes {
+		if change.Type == changeType {
+			count++
+		}
+	}
+	return count
+}
+
+// Severity of a violation sent to the notifiers
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+)
+
+// Violation is a check that found differences, it is recorded in the database and sent to the notifiers
+type Violation struct {
+	DetectedAt  time.Time
+	Namespace   string
+	Workload    string
+	Kind        string
+	Pod         string
+	Image       string
+	Remediation string
+	Severity    Severity
+	Report      *DiffReport
 }
 
 type ConnectionDB struct {
@@ -24,29 +134,94 @@ type ConnectionDB struct {
 	DbName     string
 }
 
+// WorkloadIdentity identifies a Kubernetes object found in the owner references of the pod
+type WorkloadIdentity struct {
+	APIVersion string
+	Kind       string
+	Name       string
+	UID        string
+}
+
 type KuberData struct {
-	Clientset  *kubernetes.Clientset
+	Clientset  kubernetes.Interface
 	Namespace  string
 	TargetName string
 	TargetType string
+	// Pod is the pod the sidecar runs in
+	Pod WorkloadIdentity
+	// Target is the top-level controller of the pod, TargetName and TargetType are derived from it
+	Target WorkloadIdentity
+	// Owners is the chain of controllers from the direct owner of the pod to the target
+	Owners []WorkloadIdentity
+	// Remediation is the reaction on a violation configured for the workload
+	Remediation string
 }
 
 type DeploymentData struct {
 	Image                string
+	ImageDigest          string
 	NamePod              string
 	Timestamp            string
 	NameDeployment       string
 	LabelMainProcessName string
 	ReleaseName          string
+	// ContainerName is the container the image and the baseline belong to, empty for the data of the whole pod
+	ContainerName string
+	// Containers are the containers of the pod template in their order
+	Containers []Container
+}
+
+// Container is a container of the pod template
+type Container struct {
+	Name        string
+	Image       string
+	ImageDigest string
+	// ContainerID is the ID of the running container as reported in the pod status, like containerd://<id>
+	ContainerID string
+}
+
+// MountPath is a directory of the monitored process with its own policy
+type MountPath struct {
+	// Path is relative to the root of the process, for example etc/nginx
+	Path string
+	// Filter selects the files under the path with the INCLUDE and EXCLUDE patterns
+	Filter *pathfilter.Filter
 }
 
 type ConfigMapData struct {
-	ProcName  string
-	MountPath string
+	// ProcName is the exact name of the monitored process
+	ProcName string
+	// ProcCmdline matches the command line of the monitored process
+	ProcCmdline *regexp.Regexp
+	// ProcInContainer limits the search to the processes of the container, set unless PID_CONTAINER=false
+	ProcInContainer bool
+	MountPaths      []MountPath
+	Remediation     string
+}
+
+// ProcessRestart records that the monitored process of a container was replaced, usually by a restart of the container
+type ProcessRestart struct {
+	DetectedAt time.Time
+	Container  string
+	OldPID     int
+	NewPID     int
+}
+
+// ContainerData is a container of the pod protected by the sidecar
+type ContainerData struct {
+	// DeploymentData describes the workload with the image of this container
+	DeploymentData *DeploymentData
+	ConfigMapData  *ConfigMapData
+	// ContainerID is the ID of the running container, empty until it is started
+	ContainerID string
+	// PID of the monitored process and ProcRoot, the root of its filesystem, are set once the process is found
+	PID      int
+	ProcRoot string
 }
 
 type DataFromK8sAPI struct {
 	KuberData      *KuberData
 	DeploymentData *DeploymentData
-	ConfigMapData  *ConfigMapData
+	// Containers are the containers with an entry in the ConfigMap
+	Containers []*ContainerData
 }
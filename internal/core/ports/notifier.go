@@ -0,0 +1,11 @@
+package ports
+
+import (
+	"github.com/integrity-sum/internal/core/models"
+)
+
+//go:generate mockgen -source=notifier.go -destination=mocks/mock_notifier.go
+
+type INotifier interface {
+	Notify(violation *models.Violation) error
+}
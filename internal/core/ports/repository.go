@@ -13,6 +13,10 @@ type IAppRepository interface {
 
 type IHashRepository interface {
 	SaveHashData(allHashData []*api.HashData, deploymentData *models.DeploymentData) error
-	GetHashData(dirFiles string, algorithm string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error)
+	GetHashData(mountPath string, algorithm string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error)
 	DeleteFromTable(nameDeployment string) error
+	DeleteOtherAlgorithms(algorithm string, deploymentData *models.DeploymentData) error
+	SaveImageBaseline(imageDigest string, imageName string, allHashData []*api.HashData) error
+	GetImageBaseline(imageDigest string, algorithm string) ([]*models.HashDataFromDB, error)
+	SaveViolation(violation *models.Violation) error
 }
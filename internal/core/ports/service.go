@@ -2,37 +2,53 @@ package ports
 
 import (
 	"context"
-	"os"
 	"sync"
 
 	"github.com/integrity-sum/internal/core/models"
 	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/pathfilter"
 )
 
 //go:generate mockgen -source=service.go -destination=mocks/mock_service.go
 
 type IAppService interface {
-	GetPID(configData *models.ConfigMapData) (int, error)
-	IsExistDeploymentNameInDB(deploymentName string) bool
-	LaunchHasher(ctx context.Context, dirPath string, sig chan os.Signal) []*api.HashData
-	Start(ctx context.Context, dirPath string, sig chan os.Signal, deploymentData *models.DeploymentData) error
-	Check(ctx context.Context, dirPath string, sig chan os.Signal, deploymentData *models.DeploymentData, kuberData *models.KuberData) error
+	GetPID(container *models.ContainerData) (int, error)
+	ProcRoot(pid int) string
+	TrackProcess(container *models.ContainerData, kuberData *models.KuberData) error
+	IsExistDeploymentNameInDB(deploymentName string) (bool, error)
+	HasBaseline(container *models.ContainerData) (bool, error)
+	LaunchHasher(ctx context.Context, dirPath string, filter *pathfilter.Filter) ([]*api.HashData, error)
+	Start(ctx context.Context, container *models.ContainerData) error
+	StartFromImage(container *models.ContainerData) (bool, error)
+	Check(ctx context.Context, containers []*models.ContainerData, kuberData *models.KuberData) error
+	Watch(ctx context.Context, containers []*models.ContainerData, kuberData *models.KuberData) error
 }
 
 type IHashService interface {
 	SaveHashData(allHashData []*api.HashData, deploymentData *models.DeploymentData) error
-	GetHashData(dirPath string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error)
+	GetHashData(mountPath string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error)
 	DeleteFromTable(nameDeployment string) error
+	DeleteOtherAlgorithms(deploymentData *models.DeploymentData) error
+	SaveImageBaseline(imageDigest string, imageName string, allHashData []*api.HashData) error
+	GetImageBaseline(imageDigest string) ([]*models.HashDataFromDB, error)
+	SaveViolation(violation *models.Violation) error
 	IsDataChanged(currentHashData []*api.HashData, hashSumFromDB []*models.HashDataFromDB, deploymentData *models.DeploymentData) bool
-	CreateHash(path string) (*api.HashData, error)
-	WorkerPool(jobs chan string, results chan *api.HashData)
-	Worker(wg *sync.WaitGroup, jobs <-chan string, results chan<- *api.HashData)
+	Diff(currentHashData []*api.HashData, hashSumFromDB []*models.HashDataFromDB, deploymentData *models.DeploymentData) *models.DiffReport
+	CreateHash(job api.Job) (*api.HashData, error)
+	WorkerPool(ctx context.Context, jobs <-chan api.Job, results chan<- *api.HashData)
+	Worker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan api.Job, results chan<- *api.HashData)
 }
 
 type IKuberService interface {
 	GetDataFromK8sAPI() (*models.DataFromK8sAPI, error)
 	ConnectionToK8sAPI() (*models.KuberData, error)
 	GetDataFromDeployment(kuberData *models.KuberData) (*models.DeploymentData, error)
-	GetDataFromConfigMap(kuberData *models.KuberData, deploymentData *models.DeploymentData) (*models.ConfigMapData, error)
+	GetDataFromConfigMap(kuberData *models.KuberData, deploymentData *models.DeploymentData) (map[string]*models.ConfigMapData, error)
 	RolloutDeployment(kuberData *models.KuberData) error
+	RollbackDeployment(kuberData *models.KuberData) error
+	MarkRevisionVerified(kuberData *models.KuberData) error
+	ReportViolation(kuberData *models.KuberData, violation *models.Violation) error
+	ReportProcessRestart(kuberData *models.KuberData, restart *models.ProcessRestart) error
+	GetContainerID(kuberData *models.KuberData, containerName string) (string, error)
+	AnnotateScanResult(kuberData *models.KuberData, changedFiles int) error
 }
@@ -1,142 +1,827 @@
 package services
 
 import (
-	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/integrity-sum/internal/core/models"
 	"github.com/integrity-sum/internal/core/ports"
+	"github.com/integrity-sum/internal/metrics"
+	"github.com/integrity-sum/internal/notifiers"
 	"github.com/integrity-sum/internal/repositories"
 	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/pathfilter"
+	"github.com/integrity-sum/pkg/process"
+	"github.com/integrity-sum/pkg/watcher"
 
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// BaselineSourceAuto uses the baseline computed from the image if it exists and the files found on the first run otherwise
+	BaselineSourceAuto = "auto"
+	// BaselineSourceImage requires the baseline computed from the image
+	BaselineSourceImage = "image"
+	// BaselineSourceRuntime always uses the files found on the first run
+	BaselineSourceRuntime = "runtime"
+)
+
+// Reactions on a violation, configured per workload with the REMEDIATION key of the ConfigMap
+const (
+	// RemediationObserve records the differences and sends informational notifications, like a dry run
+	RemediationObserve = "observe"
+	// RemediationAlert records the differences and sends warning notifications
+	RemediationAlert = "alert"
+	// RemediationRestart restarts the pods of the workload with the same pod template
+	RemediationRestart = "restart"
+	// RemediationRollback returns the deployment to the previous revision verified clean
+	RemediationRollback = "rollback"
+)
+
+const (
+	defaultWatchDebounce = 500 * time.Millisecond
+	maxWatchDelay        = 5 * time.Second
+	defaultProcDir       = "/proc"
+)
+
+// ErrProcessNotFound is returned when no process of a container matches, usually while the container is restarting
+var ErrProcessNotFound = errors.New("monitored process not found")
+
 type AppService struct {
 	ports.IHashService
 	ports.IAppRepository
 	ports.IKuberService
-	logger *logrus.Logger
+	// previous hashes the files with the algorithm of PREVIOUS_ALGORITHM while the baseline is migrated, nil otherwise
+	previous          ports.IHashService
+	previousAlgorithm string
+	// symlinks is the policy of following the symlinks found under the mount paths
+	symlinks string
+	// cache is used only by the hash service of the periodic check, nil if HASH_CACHE is not enabled
+	cache     *statCache
+	notifiers []ports.INotifier
+	finder    *process.Finder
+	logger    *logrus.Logger
+	// mu serializes the handling of changes found by the periodic check and by the watcher
+	mu sync.Mutex
+	// verified is set once the revision running the pod was marked as verified
+	verified bool
+	// pruned is set once the baselines saved with other algorithms were removed after a migration
+	pruned bool
+	// reported holds the differences already recorded per container when the baseline is kept after a violation
+	reported map[string]map[string]struct{}
+	// watchCancels stops the watchers of a container when its process is replaced
+	watchCancels map[*models.ContainerData]context.CancelFunc
 }
 
-// NewAppService creates a new struct AppService
-func NewAppService(r *repositories.AppRepository, algorithm string, logger *logrus.Logger) *AppService {
+// NewAppService creates a new struct AppService. When previousAlgorithm is set and differs from algorithm,
+// the baselines saved with it are migrated to algorithm. Symlinks are followed according to the symlinks policy
+func NewAppService(r *repositories.AppRepository, algorithm, previousAlgorithm, symlinks string, logger *logrus.Logger) *AppService {
 	algorithm = strings.ToUpper(algorithm)
+	previousAlgorithm = strings.ToUpper(previousAlgorithm)
 	IHashService := NewHashService(r.IHashRepository, algorithm, logger)
 	kuberService := NewKuberService(logger)
-	return &AppService{
+	procDir := os.Getenv("PROC_DIR")
+	if procDir == "" {
+		procDir = defaultProcDir
+	}
+	// The stat cache is optional, the files are read on every check by default
+	var cache *statCache
+	if enabled, _ := strconv.ParseBool(os.Getenv("HASH_CACHE")); enabled {
+		fullEvery := defaultCacheFullEvery
+		if value, err := strconv.Atoi(os.Getenv("HASH_CACHE_FULL_EVERY")); err == nil && value >= 0 {
+			fullEvery = value
+		}
+		cache = newStatCache(fullEvery)
+	}
+	as := &AppService{
 		IHashService:   IHashService,
 		IAppRepository: r,
 		IKuberService:  kuberService,
+		symlinks:       symlinks,
+		cache:          cache,
+		notifiers:      notifiers.New(logger),
+		finder:         process.NewFinder(procDir),
 		logger:         logger,
 	}
+	if previousAlgorithm != "" && previousAlgorithm != algorithm {
+		as.previous = NewHashService(r.IHashRepository, previousAlgorithm, logger)
+		as.previousAlgorithm = previousAlgorithm
+	}
+	return as
 }
 
-// GetPID getting pid by process name
-func (as *AppService) GetPID(configData *models.ConfigMapData) (int, error) {
-	if os.Chdir(os.Getenv("PROC_DIR")) != nil {
-		as.logger.Error("/proc unavailable")
-		return 0, errors.New("error changing the current working directory to the named directory")
+// GetPID finds the monitored process of the container by its exact name, its command line or the container it runs in.
+// When several processes match, the one started first is the main process, as workers are forked by it.
+// It returns 0 if no process matches
+func (as *AppService) GetPID(container *models.ContainerData) (int, error) {
+	configData := container.ConfigMapData
+	matcher := process.Matcher{Comm: configData.ProcName, Cmdline: configData.ProcCmdline}
+	if configData.ProcInContainer {
+		if container.ContainerID == "" {
+			return 0, fmt.Errorf("container %s is not started yet", container.DeploymentData.ContainerName)
+		}
+		matcher.ContainerID = container.ContainerID
 	}
 
-	files, err := os.ReadDir(".")
+	pids, err := as.finder.Find(matcher)
 	if err != nil {
-		as.logger.Error("unable to read /proc directory")
+		as.logger.Error("unable to read the proc directory ", err)
 		return 0, err
 	}
-	var pid int
-	for _, file := range files {
-		if !file.IsDir() {
-			as.logger.Info("file isn't a directory")
-			return 0, err
+	if len(pids) == 0 {
+		return 0, nil
+	}
+	if len(pids) > 1 {
+		as.logger.Debugf("Processes %v match in the container %s, monitoring %d", pids, container.DeploymentData.ContainerName, pids[0])
+	}
+	return pids[0], nil
+}
+
+// ProcRoot returns the root of the filesystem of the process as seen through the proc filesystem
+func (as *AppService) ProcRoot(pid int) string {
+	return as.finder.Root(pid)
+}
+
+// TrackProcess finds the monitored process of the container and sets its PID and root. When the container
+// restarted, its new ID is taken from the pod status. A process replacing the one found before is recorded
+// as a restart and the watchers of the container are started again on the new root
+func (as *AppService) TrackProcess(container *models.ContainerData, kuberData *models.KuberData) error {
+	containerName := container.DeploymentData.ContainerName
+	pid, err := as.GetPID(container)
+	if (err != nil || pid == 0) && container.ConfigMapData.ProcInContainer {
+		containerID, idErr := as.IKuberService.GetContainerID(kuberData, containerName)
+		if idErr != nil {
+			return idErr
+		}
+		if containerID != container.ContainerID {
+			container.ContainerID = containerID
+			pid, err = as.GetPID(container)
 		}
+	}
+	if err != nil {
+		return err
+	}
+	if pid == 0 {
+		return fmt.Errorf("%w in the container %s", ErrProcessNotFound, containerName)
+	}
+	if pid == container.PID {
+		return nil
+	}
 
-		// Our directory name should convert to integer if it's a PID
-		pid, err = strconv.Atoi(file.Name())
-		if err != nil {
-			return 0, err
+	as.mu.Lock()
+	oldPID := container.PID
+	container.PID = pid
+	container.ProcRoot = as.ProcRoot(pid)
+	cancelWatch := as.watchCancels[container]
+	as.mu.Unlock()
+	if oldPID == 0 {
+		return nil
+	}
+
+	as.logger.Warnf("Monitored process of the container %s was replaced, pid %d -> %d", containerName, oldPID, pid)
+	metrics.IncProcessRestarts(containerName)
+	_ = as.IKuberService.ReportProcessRestart(kuberData, &models.ProcessRestart{
+		DetectedAt: time.Now().UTC(),
+		Container:  containerName,
+		OldPID:     oldPID,
+		NewPID:     pid,
+	})
+	if cancelWatch != nil {
+		cancelWatch()
+	}
+	return nil
+}
+
+// LaunchHasher takes a path to a directory and returns HashData of the files selected by the filter.
+// If the context is done before all files are hashed, the walker and the workers are stopped and its error is returned
+func (as *AppService) LaunchHasher(ctx context.Context, dirPath string, filter *pathfilter.Filter) ([]*api.HashData, error) {
+	return as.launchHasher(ctx, as.IHashService, "/", dirPath, filter)
+}
+
+// launchHasher hashes the files with the algorithm of the hash service, symlinks never lead out of root
+func (as *AppService) launchHasher(ctx context.Context, hashService ports.IHashService, root, dirPath string, filter *pathfilter.Filter) ([]*api.HashData, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	symlinks := api.SymlinkPolicy{Mode: as.symlinks, Root: root}
+	jobs := make(chan api.Job)
+	results := make(chan *api.HashData)
+	walkErr := make(chan error, 1)
+	go hashService.WorkerPool(ctx, jobs, results)
+	go func() {
+		walkErr <- api.SearchFilePath(ctx, dirPath, filter, symlinks, jobs, as.logger)
+	}()
+
+	allHashData, err := api.Result(ctx, results)
+	// Stop the pipeline and wait until the workers closed results, so no goroutine stays blocked
+	cancel()
+	for range results {
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := <-walkErr; err != nil {
+		return nil, err
+	}
+	return allHashData, nil
+}
+
+// IsExistDeploymentNameInDB checks if the database is empty
+func (as *AppService) IsExistDeploymentNameInDB(deploymentName string) (bool, error) {
+	isEmptyDB, err := as.IAppRepository.IsExistDeploymentNameInDB(deploymentName)
+	if err != nil {
+		metrics.IncDatabaseErrors("is_exist_deployment")
+		as.logger.Errorf("database check error %s", err)
+		return false, err
+	}
+	return isEmptyDB, nil
+}
+
+// HasBaseline checks if the baseline of the container of the pod is saved, with the current or the previous algorithm.
+// The baseline of a container is saved at once, so a mount path with rows means every mount path has them
+func (as *AppService) HasBaseline(container *models.ContainerData) (bool, error) {
+	hashServices := []ports.IHashService{as.IHashService}
+	if as.previous != nil {
+		hashServices = append(hashServices, as.previous)
+	}
+	for _, hashService := range hashServices {
+		for _, mountPath := range container.ConfigMapData.MountPaths {
+			hashData, err := hashService.GetHashData(mountPath.Path, container.DeploymentData)
+			if err != nil {
+				return false, err
+			}
+			if len(hashData) > 0 {
+				return true, nil
+			}
 		}
+	}
+	return false, nil
+}
 
-		// Open the /proc/xxx/stat file to read the name
-		f, err := os.Open(file.Name() + "/stat")
+// Start getting the hash sum of all files under every mount path of the container, outputs to os.Stdout and saves to the database
+func (as *AppService) Start(ctx context.Context, container *models.ContainerData) error {
+	var allHashData []*api.HashData
+	for _, mountPath := range container.ConfigMapData.MountPaths {
+		hashData, err := as.hashMountPath(ctx, as.IHashService, container.ProcRoot, mountPath)
 		if err != nil {
-			as.logger.Error("unable to open", file.Name())
-			return 0, err
+			as.logger.Error("Error while hashing files ", err)
+			return err
 		}
-		defer f.Close()
+		allHashData = append(allHashData, hashData...)
+	}
+	// The baselines of all mount paths are saved at once, so none of them is missing after a failure
+	err := as.IHashService.SaveHashData(allHashData, container.DeploymentData)
+	if err != nil {
+		as.logger.Error("Error save hash data to database ", err)
+		return err
+	}
+
+	return nil
+}
+
+// hashMountPath hashes the files selected by the policy of the mount path with the algorithm of the hash service
+// and records the mount path and the paths as the process sees them in the results
+func (as *AppService) hashMountPath(ctx context.Context, hashService ports.IHashService, procRoot string, mountPath models.MountPath) ([]*api.HashData, error) {
+	hashData, err := as.launchHasher(ctx, hashService, procRoot, mountDir(procRoot, mountPath), mountPath.Filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range hashData {
+		data.FullFilePath = processPath(procRoot, data.FullFilePath)
+		data.MountPath = mountPath.Path
+	}
+	return hashData, nil
+}
+
+// StartFromImage saves the baseline computed offline from the image of the container as the data of the pod.
+// It returns false if the baseline for the image digest doesn't cover every mount path
+func (as *AppService) StartFromImage(container *models.ContainerData) (bool, error) {
+	deploymentData := container.DeploymentData
+	if deploymentData.ImageDigest == "" {
+		as.logger.Warn("Digest of the container image is unknown, baseline from the image can't be used")
+		return false, nil
+	}
+
+	imageBaseline, err := as.IHashService.GetImageBaseline(deploymentData.ImageDigest)
+	if err != nil {
+		as.logger.Error("Error getting image baseline from database ", err)
+		return false, err
+	}
 
-		r := bufio.NewReader(f)
-		scanner := bufio.NewScanner(r)
-		scanner.Split(bufio.ScanWords)
-		for scanner.Scan() {
-			if strings.Contains(scanner.Text(), configData.ProcName) {
-				return pid, nil
+	var allHashData []*api.HashData
+	for _, mountPath := range container.ConfigMapData.MountPaths {
+		root := path.Clean("/" + mountPath.Path)
+		found := 0
+		for _, data := range imageBaseline {
+			if root != "/" && data.FullFilePath != root && !strings.HasPrefix(data.FullFilePath, root+"/") {
+				continue
 			}
+			if !mountPath.Filter.Match(pathfilter.Rel(root, data.FullFilePath)) {
+				continue
+			}
+			allHashData = append(allHashData, &api.HashData{
+				Hash:         data.Hash,
+				FileName:     data.FileName,
+				FullFilePath: data.FullFilePath,
+				MountPath:    mountPath.Path,
+				Algorithm:    data.Algorithm,
+				Metadata:     data.Metadata,
+			})
+			found++
+		}
+		if found == 0 {
+			as.logger.Warnf("Baseline of the image %s has no files under %s", deploymentData.ImageDigest, root)
+			return false, nil
 		}
 	}
 
-	return pid, nil
+	err = as.IHashService.SaveHashData(allHashData, deploymentData)
+	if err != nil {
+		as.logger.Error("Error save hash data to database ", err)
+		return false, err
+	}
+	return true, nil
 }
 
-// LaunchHasher takes a path to a directory and returns HashData
-func (as *AppService) LaunchHasher(ctx context.Context, dirPath string, sig chan os.Signal) []*api.HashData {
-	jobs := make(chan string)
-	results := make(chan *api.HashData)
-	go as.IHashService.WorkerPool(jobs, results)
-	go api.SearchFilePath(dirPath, jobs, as.logger)
-	allHashData := api.Result(ctx, results, sig)
+// Check getting the hash sum of all files of the containers, matches them and outputs to os.Stdout changes.
+// Every container and mount path is compared with its own baseline. The revision is marked verified
+// when no container has differences. The monitored processes are looked up first, so a restarted
+// container is checked on the root of its new process
+func (as *AppService) Check(ctx context.Context, containers []*models.ContainerData, kuberData *models.KuberData) error {
+	start := time.Now()
+	for _, container := range containers {
+		if err := as.TrackProcess(container, kuberData); err != nil {
+			as.logger.Error("Error while finding the monitored process ", err)
+			return err
+		}
+	}
+
+	hashService := as.IHashService
+	hashed := false
+	if as.cache != nil {
+		hashService = as.cachedHashService()
+		if as.cache.begin() {
+			as.logger.Info("Reading all files, the hash cache is not used in this check")
+		}
+		// The check is finished on every path, a full check which failed before all files were hashed is repeated
+		defer func() {
+			hits, misses := as.cache.end(hashed)
+			metrics.AddCacheResults(hits, misses)
+			as.logger.Infof("Hash cache: %d files unchanged, %d files read", hits, misses)
+		}()
+	}
+	hashDataCurrent := make([][][]*api.HashData, len(containers))
+	for i, container := range containers {
+		for _, mountPath := range container.ConfigMapData.MountPaths {
+			hashData, err := as.hashMountPath(ctx, hashService, container.ProcRoot, mountPath)
+			if err != nil {
+				as.logger.Error("Error while hashing files ", err)
+				return err
+			}
+			hashDataCurrent[i] = append(hashDataCurrent[i], hashData)
+		}
+		// Files of a process which exited during the check are missing, they are not reported as deleted
+		if _, err := os.Stat(container.ProcRoot); err != nil {
+			return fmt.Errorf("%w in the container %s, it exited during the check", ErrProcessNotFound, container.DeploymentData.ContainerName)
+		}
+	}
+	hashed = true
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	reports := make([]*models.DiffReport, len(containers))
+	changes := 0
+	baselineSize := 0
+	for i, container := range containers {
+		reports[i] = &models.DiffReport{}
+		for j, mountPath := range container.ConfigMapData.MountPaths {
+			dataFromDBbyPodName, err := as.IHashService.GetHashData(mountPath.Path, container.DeploymentData)
+			if err != nil {
+				as.logger.Error("Error getting hash data from database ", err)
+				return err
+			}
+			// Files excluded after the baseline was saved are not reported as deleted
+			dataFromDBbyPodName = filterHashData(path.Clean("/"+mountPath.Path), mountPath.Filter, dataFromDBbyPodName)
 
-	return allHashData
+			var mountPathReport *models.DiffReport
+			if len(dataFromDBbyPodName) == 0 && as.previous != nil {
+				var previousSize int
+				mountPathReport, previousSize, err = as.migrateBaseline(ctx, container, mountPath, hashDataCurrent[i][j])
+				if err != nil {
+					return err
+				}
+				baselineSize += previousSize
+			}
+			if mountPathReport == nil {
+				// The baseline was removed after a violation and the workload is being replaced, every file would be reported as added
+				if len(dataFromDBbyPodName) == 0 {
+					continue
+				}
+				baselineSize += len(dataFromDBbyPodName)
+				mountPathReport = as.IHashService.Diff(comparableHashData(hashDataCurrent[i][j], dataFromDBbyPodName), dataFromDBbyPodName, container.DeploymentData)
+			}
+			reports[i].Changes = append(reports[i].Changes, mountPathReport.Changes...)
+		}
+		changes += len(reports[i].Changes)
+	}
+
+	metrics.ObserveScan(metrics.TriggerPeriodic, time.Since(start))
+	_ = as.IKuberService.AnnotateScanResult(kuberData, changes)
+	if changes == 0 && !as.verified && baselineSize > 0 {
+		as.verified = as.IKuberService.MarkRevisionVerified(kuberData) == nil
+	}
+	// The migration is over when PREVIOUS_ALGORITHM is removed, the old baselines are dropped after a clean check
+	if changes == 0 && !as.pruned && as.previous == nil && baselineSize > 0 {
+		as.pruned = as.pruneAlgorithms(containers)
+	}
+	for i, container := range containers {
+		if err := as.handleReport(reports[i], true, container.DeploymentData, kuberData); err != nil {
+			return err
+		}
+		// The whole workload is restarted or rolled back, the other containers go with it
+		if reports[i].IsChanged() && isEnforcing(kuberData.Remediation) {
+			break
+		}
+	}
+	return nil
 }
 
-// IsExistDeploymentNameInDB checks if the database is empty
-func (as *AppService) IsExistDeploymentNameInDB(deploymentName string) bool {
-	isEmptyDB, err := as.IAppRepository.IsExistDeploymentNameInDB(deploymentName)
-	if err != nil {
-		as.logger.Fatalf("database check error %s", err)
+// cachedHashService returns the hash service of the periodic check which takes the hash sums of unchanged files from the cache.
+// The baseline and the watcher hash with the service without the cache, so they don't change the cache between begin and end
+func (as *AppService) cachedHashService() ports.IHashService {
+	hs, ok := as.IHashService.(*HashService)
+	if !ok {
+		return as.IHashService
 	}
-	return isEmptyDB
+	cached := *hs
+	cached.cache = as.cache
+	return cached
 }
 
-// Start getting the hash sum of all files, outputs to os.Stdout and saves to the database
-func (as *AppService) Start(ctx context.Context, dirPath string, sig chan os.Signal, deploymentData *models.DeploymentData) error {
-	allHashData := as.LaunchHasher(ctx, dirPath, sig)
-	err := as.IHashService.SaveHashData(allHashData, deploymentData)
+// migrateBaseline verifies the files of the mount path against the baseline saved with the previous algorithm
+// and only if they are unchanged saves hashData, computed with the current algorithm, as the new baseline.
+// It returns the report and the size of the previous baseline, the report is nil if there is no baseline with the previous algorithm either
+func (as *AppService) migrateBaseline(ctx context.Context, container *models.ContainerData, mountPath models.MountPath, hashData []*api.HashData) (*models.DiffReport, int, error) {
+	deploymentData := container.DeploymentData
+	previousBaseline, err := as.previous.GetHashData(mountPath.Path, deploymentData)
+	if err != nil {
+		as.logger.Error("Error getting hash data from database ", err)
+		return nil, 0, err
+	}
+	previousBaseline = filterHashData(path.Clean("/"+mountPath.Path), mountPath.Filter, previousBaseline)
+	if len(previousBaseline) == 0 {
+		return nil, 0, nil
+	}
+
+	previousHashData, err := as.hashMountPath(ctx, as.previous, container.ProcRoot, mountPath)
 	if err != nil {
+		as.logger.Error("Error while hashing files ", err)
+		return nil, 0, err
+	}
+	if _, err := os.Stat(container.ProcRoot); err != nil {
+		return nil, 0, fmt.Errorf("%w in the container %s, it exited during the check", ErrProcessNotFound, deploymentData.ContainerName)
+	}
+
+	report := as.previous.Diff(comparableHashData(previousHashData, previousBaseline), previousBaseline, deploymentData)
+	if report.IsChanged() {
+		as.logger.Warnf("Baseline of %s in the container %s is not migrated from %s, the files changed", mountPath.Path, deploymentData.ContainerName, as.previousAlgorithm)
+		return report, len(previousBaseline), nil
+	}
+	if err := as.IHashService.SaveHashData(hashData, deploymentData); err != nil {
 		as.logger.Error("Error save hash data to database ", err)
+		return nil, 0, err
+	}
+	as.logger.Infof("Baseline of %s in the container %s migrated from %s, %d files", mountPath.Path, deploymentData.ContainerName, as.previousAlgorithm, len(hashData))
+	return report, len(previousBaseline), nil
+}
+
+// pruneAlgorithms removes the baselines of the containers saved with other algorithms, it returns false on failure so it is retried
+func (as *AppService) pruneAlgorithms(containers []*models.ContainerData) bool {
+	for _, container := range containers {
+		if err := as.IHashService.DeleteOtherAlgorithms(container.DeploymentData); err != nil {
+			as.logger.Error("Error while deleting baselines of other algorithms ", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Watch re-hashes the files as soon as inotify reports them changed, between the periodic checks.
+// Every mount path of every container is watched separately, Watch returns when all watchers stopped
+func (as *AppService) Watch(ctx context.Context, containers []*models.ContainerData, kuberData *models.KuberData) error {
+	debounce := defaultWatchDebounce
+	if value, err := strconv.Atoi(os.Getenv("WATCH_DEBOUNCE_MS")); err == nil && value > 0 {
+		debounce = time.Duration(value) * time.Millisecond
+	}
+
+	errs := make(chan error)
+	for _, container := range containers {
+		go func(container *models.ContainerData) {
+			errs <- as.watchContainer(ctx, container, debounce, kuberData)
+		}(container)
+	}
+
+	var err error
+	for range containers {
+		if watchErr := <-errs; watchErr != nil && err == nil {
+			err = watchErr
+		}
+	}
+	return err
+}
+
+// watchContainer watches the mount paths of the container on the root of its current process until the context is done.
+// The watchers are stopped by TrackProcess when the process is replaced and started again on the root of the new one
+func (as *AppService) watchContainer(ctx context.Context, container *models.ContainerData, debounce time.Duration, kuberData *models.KuberData) error {
+	for {
+		watchCtx, cancel := context.WithCancel(ctx)
+		as.mu.Lock()
+		procRoot := container.ProcRoot
+		if as.watchCancels == nil {
+			as.watchCancels = make(map[*models.ContainerData]context.CancelFunc)
+		}
+		as.watchCancels[container] = cancel
+		as.mu.Unlock()
+
+		err := as.watchMountPaths(watchCtx, container, procRoot, debounce, kuberData)
+		// The watchers stopped by themselves, unless the process exited
+		if watchCtx.Err() == nil {
+			if _, statErr := os.Stat(procRoot); statErr == nil {
+				cancel()
+				return err
+			}
+			// The process exited, the watchers are started again once the check finds the new one
+			<-watchCtx.Done()
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		as.logger.Infof("Watching the container %s again after its process was replaced", container.DeploymentData.ContainerName)
+	}
+}
+
+// watchMountPaths runs a watcher for every mount path of the container, all of them are stopped when one fails
+func (as *AppService) watchMountPaths(ctx context.Context, container *models.ContainerData, procRoot string, debounce time.Duration, kuberData *models.KuberData) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mountPaths := container.ConfigMapData.MountPaths
+	errs := make(chan error)
+	for _, mountPath := range mountPaths {
+		go func(mountPath models.MountPath) {
+			errs <- as.watchMountPath(ctx, container, procRoot, mountPath, debounce, kuberData)
+		}(mountPath)
+	}
+
+	var err error
+	for range mountPaths {
+		if watchErr := <-errs; watchErr != nil && err == nil {
+			err = watchErr
+			cancel()
+		}
+	}
+	return err
+}
+
+// watchMountPath checks the paths changed under one mount path until the context is done. A failed check
+// of a batch is logged and the watching goes on, the periodic check covers the files it missed
+func (as *AppService) watchMountPath(ctx context.Context, container *models.ContainerData, procRoot string, mountPath models.MountPath, debounce time.Duration, kuberData *models.KuberData) error {
+	dirPath := mountDir(procRoot, mountPath)
+	w, err := watcher.New(dirPath, mountPath.Filter, debounce, maxWatchDelay, as.logger)
+	if err != nil {
+		as.logger.Errorf("Error while watching directory %s %s", mountPath.Path, err)
 		return err
 	}
+	defer w.Close()
 
+	batches := make(chan []string)
+	go func() {
+		if err := w.Run(ctx, batches); err != nil && !errors.Is(err, context.Canceled) {
+			as.logger.Error("Watching stopped ", err)
+		}
+	}()
+
+	for paths := range batches {
+		as.logger.Infof("Checking %d changed paths under %s", len(paths), mountPath.Path)
+		if err := as.checkPaths(container, procRoot, mountPath, paths, kuberData); err != nil {
+			as.logger.Errorf("Error while checking the changed paths under %s %s", mountPath.Path, err)
+		}
+	}
 	return nil
 }
 
-// Check getting the hash sum of all files, matches them and outputs to os.Stdout changes
-func (as *AppService) Check(ctx context.Context, dirPath string, sig chan os.Signal, deploymentData *models.DeploymentData, kuberData *models.KuberData) error {
-	hashDataCurrentByDirPath := as.LaunchHasher(ctx, dirPath, sig)
+// checkPaths hashes only the given paths selected by the policy of the mount path and matches them with the data in the database.
+// Paths found under the root of a process which exited or was replaced are skipped, the periodic check handles the new process
+func (as *AppService) checkPaths(container *models.ContainerData, procRoot string, mountPath models.MountPath, paths []string, kuberData *models.KuberData) error {
+	start := time.Now()
+	as.mu.Lock()
+	defer as.mu.Unlock()
 
-	dataFromDBbyPodName, err := as.IHashService.GetHashData(dirPath, deploymentData)
+	if procRoot != container.ProcRoot {
+		return nil
+	}
+	if _, err := os.Stat(procRoot); err != nil {
+		return nil
+	}
+	deploymentData := container.DeploymentData
+
+	dataFromDBbyPodName, err := as.IHashService.GetHashData(mountPath.Path, deploymentData)
 	if err != nil {
 		as.logger.Error("Error getting hash data from database ", err)
 		return err
 	}
+	// The baseline was removed after a violation and is not saved again yet
+	if len(dataFromDBbyPodName) == 0 {
+		return nil
+	}
 
-	isDataChanged := as.IHashService.IsDataChanged(hashDataCurrentByDirPath, dataFromDBbyPodName, deploymentData)
-	if isDataChanged {
-		err := as.IHashService.DeleteFromTable(deploymentData.NameDeployment)
+	dirPath := mountDir(procRoot, mountPath)
+	symlinks := api.SymlinkPolicy{Mode: as.symlinks, Root: procRoot}
+	changed := make(map[string]struct{}, len(paths))
+	var hashDataCurrent []*api.HashData
+	for _, path := range paths {
+		if !mountPath.Filter.Match(pathfilter.Rel(dirPath, path)) {
+			continue
+		}
+		changed[processPath(procRoot, path)] = struct{}{}
+		info, err := os.Lstat(path)
 		if err != nil {
-			as.logger.Error("Error while deleting rows in database", err)
-			return err
+			continue
 		}
-
-		err = as.IKuberService.RolloutDeployment(kuberData)
+		data, err := as.IHashService.CreateHash(symlinks.NewJob(dirPath, path, info))
 		if err != nil {
-			as.logger.Error("Error while rolling out deployment in k8s", err)
+			continue
+		}
+		data.FullFilePath = processPath(procRoot, data.FullFilePath)
+		data.MountPath = mountPath.Path
+		hashDataCurrent = append(hashDataCurrent, data)
+	}
+
+	var hashDataFromDB []*models.HashDataFromDB
+	for _, data := range dataFromDBbyPodName {
+		if _, ok := changed[data.FullFilePath]; ok {
+			hashDataFromDB = append(hashDataFromDB, data)
+		}
+	}
+
+	report := as.IHashService.Diff(comparableHashData(hashDataCurrent, dataFromDBbyPodName), hashDataFromDB, deploymentData)
+	metrics.ObserveScan(metrics.TriggerWatch, time.Since(start))
+	return as.handleReport(report, false, deploymentData, kuberData)
+}
+
+// filterHashData drops the rows of the files not selected by the filter
+func filterHashData(dirPath string, filter *pathfilter.Filter, hashData []*models.HashDataFromDB) []*models.HashDataFromDB {
+	if filter.IsEmpty() {
+		return hashData
+	}
+	var selected []*models.HashDataFromDB
+	for _, data := range hashData {
+		if filter.Match(pathfilter.Rel(dirPath, data.FullFilePath)) {
+			selected = append(selected, data)
+		}
+	}
+	return selected
+}
+
+// handleReport reacts on the differences found by a check according to the remediation of the workload.
+// Full tells whether the report covers all files or only the paths reported by the watcher
+func (as *AppService) handleReport(report *models.DiffReport, full bool, deploymentData *models.DeploymentData, kuberData *models.KuberData) error {
+	enforce := isEnforcing(kuberData.Remediation)
+	if !enforce {
+		// The baseline is kept, so the same differences are found again by every check
+		report = as.unreported(deploymentData.ContainerName, report, full)
+	}
+	if !report.IsChanged() {
+		return nil
+	}
+	as.logReport(report)
+	as.recordViolation(report, deploymentData, kuberData)
+	if !enforce {
+		return nil
+	}
+
+	err := as.IHashService.DeleteFromTable(deploymentData.NameDeployment)
+	if err != nil {
+		as.logger.Error("Error while deleting rows in database", err)
+		return err
+	}
+
+	if kuberData.Remediation == RemediationRollback {
+		err = as.IKuberService.RollbackDeployment(kuberData)
+		if err == nil {
+			metrics.IncRemediations(RemediationRollback)
+			return nil
+		}
+		if !errors.Is(err, ErrRollbackUnavailable) {
+			as.logger.Error("Error while rolling back deployment in k8s", err)
 			return err
 		}
+		as.logger.Warn("Rollback is unavailable, restarting instead")
+	}
+
+	err = as.IKuberService.RolloutDeployment(kuberData)
+	if err != nil {
+		as.logger.Error("Error while rolling out deployment in k8s", err)
+		return err
 	}
+	metrics.IncRemediations(RemediationRestart)
 	return nil
 }
+
+// isEnforcing reports whether the remediation restarts or rolls back the workload
+func isEnforcing(remediation string) bool {
+	return remediation != RemediationObserve && remediation != RemediationAlert
+}
+
+// unreported returns the differences of the container which were not recorded yet. A full report also forgets
+// the differences of the container which disappeared, so they are recorded again if they come back
+func (as *AppService) unreported(containerName string, report *models.DiffReport, full bool) *models.DiffReport {
+	if as.reported == nil {
+		as.reported = make(map[string]map[string]struct{})
+	}
+	reported := as.reported[containerName]
+	if reported == nil {
+		reported = make(map[string]struct{})
+		as.reported[containerName] = reported
+	}
+	current := make(map[string]struct{}, len(report.Changes))
+	result := &models.DiffReport{}
+	for _, change := range report.Changes {
+		key := strings.Join([]string{string(change.Type), change.FullFilePath, change.NewHash, change.NewImage}, "\x00")
+		current[key] = struct{}{}
+		if _, ok := reported[key]; !ok {
+			reported[key] = struct{}{}
+			result.Changes = append(result.Changes, change)
+		}
+	}
+	if full {
+		for key := range reported {
+			if _, ok := current[key]; !ok {
+				delete(reported, key)
+			}
+		}
+	}
+	return result
+}
+
+// recordViolation saves the differences to the database, emits Kubernetes events and sends them to the notifiers,
+// failures are logged and don't prevent the remediation
+func (as *AppService) recordViolation(report *models.DiffReport, deploymentData *models.DeploymentData, kuberData *models.KuberData) {
+	violation := &models.Violation{
+		DetectedAt:  time.Now().UTC(),
+		Namespace:   kuberData.Namespace,
+		Workload:    kuberData.TargetName,
+		Kind:        kuberData.TargetType,
+		Pod:         deploymentData.NamePod,
+		Image:       deploymentData.Image,
+		Remediation: kuberData.Remediation,
+		Severity:    models.SeverityWarning,
+		Report:      report,
+	}
+	if kuberData.Remediation == RemediationObserve {
+		violation.Severity = models.SeverityInfo
+	}
+	for _, changeType := range []models.ChangeType{models.ChangeAdded, models.ChangeDeleted, models.ChangeModified, models.ChangeImageChanged} {
+		metrics.AddViolations(string(changeType), report.Count(changeType))
+	}
+
+	if err := as.IHashService.SaveViolation(violation); err != nil {
+		as.logger.Error("Error while saving violation to database ", err)
+	}
+	_ = as.IKuberService.ReportViolation(kuberData, violation)
+	for _, notifier := range as.notifiers {
+		if err := notifier.Notify(violation); err != nil {
+			as.logger.Error("Error while sending notification ", err)
+		}
+	}
+}
+
+// logReport outputs every difference found during the check and a summary of them
+func (as *AppService) logReport(report *models.DiffReport) {
+	for _, change := range report.Changes {
+		switch change.Type {
+		case models.ChangeAdded:
+			as.logger.Warnf("Added: file - %s the path %s hash sum %s", change.FileName, change.FullFilePath, change.NewHash)
+		case models.ChangeDeleted:
+			as.logger.Warnf("Deleted: file - %s the path %s hash sum %s", change.FileName, change.FullFilePath, change.OldHash)
+		case models.ChangeModified:
+			reasons := change.ReasonsString()
+			if reasons == "" {
+				reasons = fmt.Sprintf("old hash sum %s, new hash sum %s", change.OldHash, change.NewHash)
+			}
+			as.logger.Warnf("Changed: file - %s the path %s, %s", change.FileName, change.FullFilePath, reasons)
+		case models.ChangeImageChanged:
+			as.logger.Warnf("Changed image container: file - %s the path %s, old image %s, new image %s", change.FileName, change.FullFilePath, change.OldImage, change.NewImage)
+		}
+	}
+	as.logger.Warnf("Integrity check found %d changes: added %d, deleted %d, modified %d, image changed %d",
+		len(report.Changes), report.Count(models.ChangeAdded), report.Count(models.ChangeDeleted),
+		report.Count(models.ChangeModified), report.Count(models.ChangeImageChanged))
+}
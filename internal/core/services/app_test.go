@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/internal/core/ports"
+	mock_ports "github.com/integrity-sum/internal/core/ports/mocks"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/process"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleReport(t *testing.T) {
+	report := &models.DiffReport{Changes: []*models.FileChange{
+		{Type: models.ChangeModified, FileName: "nginx.conf", FullFilePath: "/etc/nginx/nginx.conf", OldHash: "1", NewHash: "2"},
+	}}
+	deploymentData := &models.DeploymentData{NamePod: "app-0", NameDeployment: "app"}
+
+	testTable := []struct {
+		name         string
+		remediation  string
+		mockBehavior func(hs *mock_ports.MockIHashService, ks *mock_ports.MockIKuberService, n *mock_ports.MockINotifier)
+	}{
+		{
+			name:        "observe records once and keeps the baseline",
+			remediation: RemediationObserve,
+			mockBehavior: func(hs *mock_ports.MockIHashService, ks *mock_ports.MockIKuberService, n *mock_ports.MockINotifier) {
+				hs.EXPECT().SaveViolation(gomock.Any()).Return(nil).Times(1)
+				n.EXPECT().Notify(gomock.Any()).DoAndReturn(func(violation *models.Violation) error {
+					assert.Equal(t, models.SeverityInfo, violation.Severity)
+					return nil
+				}).Times(1)
+			},
+		},
+		{
+			name:        "alert records once with a warning",
+			remediation: RemediationAlert,
+			mockBehavior: func(hs *mock_ports.MockIHashService, ks *mock_ports.MockIKuberService, n *mock_ports.MockINotifier) {
+				hs.EXPECT().SaveViolation(gomock.Any()).Return(nil).Times(1)
+				n.EXPECT().Notify(gomock.Any()).DoAndReturn(func(violation *models.Violation) error {
+					assert.Equal(t, models.SeverityWarning, violation.Severity)
+					return nil
+				}).Times(1)
+			},
+		},
+		{
+			name:        "restart records and restarts on every report",
+			remediation: RemediationRestart,
+			mockBehavior: func(hs *mock_ports.MockIHashService, ks *mock_ports.MockIKuberService, n *mock_ports.MockINotifier) {
+				hs.EXPECT().SaveViolation(gomock.Any()).Return(nil).Times(2)
+				n.EXPECT().Notify(gomock.Any()).Return(nil).Times(2)
+				hs.EXPECT().DeleteFromTable("app").Return(nil).Times(2)
+				ks.EXPECT().RolloutDeployment(gomock.Any()).Return(nil).Times(2)
+			},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			hs := mock_ports.NewMockIHashService(c)
+			ks := mock_ports.NewMockIKuberService(c)
+			n := mock_ports.NewMockINotifier(c)
+			ks.EXPECT().ReportViolation(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			testCase.mockBehavior(hs, ks, n)
+
+			as := &AppService{IHashService: hs, IKuberService: ks, notifiers: []ports.INotifier{n}, logger: logrus.New()}
+			kuberData := &models.KuberData{TargetName: "app", Remediation: testCase.remediation}
+			for i := 0; i < 2; i++ {
+				assert.NoError(t, as.handleReport(report, true, deploymentData, kuberData))
+			}
+		})
+	}
+}
+
+func TestCheckReportsOncePerContainer(t *testing.T) {
+	procDir := t.TempDir()
+	startProcess := func(pid, containerID string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(procDir, pid, "root", "etc", "nginx"), 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "root", "etc", "nginx", "nginx.conf"), []byte("abc"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "cgroup"), []byte("0::/kubepods/pod1/cri-containerd-"+containerID+".scope\n"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "stat"), []byte(pid+" (nginx) S"+strings.Repeat(" 0", 18)+" 100\n"), 0o600))
+	}
+	startProcess("7", "aaa")
+	startProcess("8", "bbb")
+	newContainer := func(name, containerID string) *models.ContainerData {
+		return &models.ContainerData{
+			DeploymentData: &models.DeploymentData{NamePod: "app-0", NameDeployment: "app", ContainerName: name},
+			ConfigMapData:  &models.ConfigMapData{ProcInContainer: true, MountPaths: []models.MountPath{{Path: "etc/nginx"}}},
+			ContainerID:    containerID,
+		}
+	}
+	containers := []*models.ContainerData{newContainer("nginx", "containerd://aaa"), newContainer("sidecar", "containerd://bbb")}
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+	r := mock_ports.NewMockIHashRepository(c)
+	ks := mock_ports.NewMockIKuberService(c)
+	n := mock_ports.NewMockINotifier(c)
+	// The file of the first container is modified, the second one is unchanged
+	baselines := map[string]string{
+		"nginx":   "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		"sidecar": "a9993e364706816aba3e25717850c26c9cd0d89d",
+	}
+	r.EXPECT().GetHashData("etc/nginx", "SHA1", gomock.Any()).DoAndReturn(func(_, _ string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error) {
+		return []*models.HashDataFromDB{
+			{FileName: "nginx.conf", FullFilePath: "/etc/nginx/nginx.conf", MountPath: "etc/nginx", Hash: baselines[deploymentData.ContainerName], Algorithm: "SHA1"},
+		}, nil
+	}).AnyTimes()
+	ks.EXPECT().AnnotateScanResult(gomock.Any(), 1).Return(nil).AnyTimes()
+	ks.EXPECT().ReportViolation(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	r.EXPECT().SaveViolation(gomock.Any()).Return(nil).Times(1)
+	n.EXPECT().Notify(gomock.Any()).DoAndReturn(func(violation *models.Violation) error {
+		require.Len(t, violation.Report.Changes, 1)
+		assert.Equal(t, models.ChangeModified, violation.Report.Changes[0].Type)
+		return nil
+	}).Times(1)
+
+	logger := logrus.New()
+	as := &AppService{
+		IHashService:  NewHashService(r, "SHA1", logger),
+		IKuberService: ks,
+		notifiers:     []ports.INotifier{n},
+		finder:        process.NewFinder(procDir),
+		logger:        logger,
+	}
+	kuberData := &models.KuberData{TargetName: "app", Remediation: RemediationAlert}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, as.Check(context.Background(), containers, kuberData))
+	}
+}
+
+func TestCheckAfterRemediation(t *testing.T) {
+	procDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(procDir, "7", "root", "etc", "nginx"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "7", "root", "etc", "nginx", "nginx.conf"), []byte("abc"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "7", "cgroup"), []byte("0::/kubepods/pod1/cri-containerd-aaa.scope\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "7", "stat"), []byte("7 (nginx) S"+strings.Repeat(" 0", 18)+" 100\n"), 0o600))
+	containers := []*models.ContainerData{{
+		DeploymentData: &models.DeploymentData{NamePod: "app-0", NameDeployment: "app", ContainerName: "nginx"},
+		ConfigMapData:  &models.ConfigMapData{ProcInContainer: true, MountPaths: []models.MountPath{{Path: "etc/nginx"}}},
+		ContainerID:    "containerd://aaa",
+	}}
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+	r := mock_ports.NewMockIHashRepository(c)
+	ks := mock_ports.NewMockIKuberService(c)
+	n := mock_ports.NewMockINotifier(c)
+	// The file is modified, the baseline is removed by the remediation
+	baseline := []*models.HashDataFromDB{
+		{FileName: "nginx.conf", FullFilePath: "/etc/nginx/nginx.conf", MountPath: "etc/nginx", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709", Algorithm: "SHA1"},
+	}
+	r.EXPECT().GetHashData("etc/nginx", "SHA1", gomock.Any()).DoAndReturn(func(_, _ string, _ *models.DeploymentData) ([]*models.HashDataFromDB, error) {
+		return baseline, nil
+	}).Times(2)
+	r.EXPECT().DeleteFromTable("app").DoAndReturn(func(string) error {
+		baseline = nil
+		return nil
+	}).Times(1)
+	ks.EXPECT().AnnotateScanResult(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	ks.EXPECT().ReportViolation(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	ks.EXPECT().RolloutDeployment(gomock.Any()).Return(nil).Times(1)
+	r.EXPECT().SaveViolation(gomock.Any()).Return(nil).Times(1)
+	n.EXPECT().Notify(gomock.Any()).Return(nil).Times(1)
+
+	logger := logrus.New()
+	as := &AppService{
+		IHashService:  NewHashService(r, "SHA1", logger),
+		IKuberService: ks,
+		notifiers:     []ports.INotifier{n},
+		finder:        process.NewFinder(procDir),
+		logger:        logger,
+	}
+	kuberData := &models.KuberData{TargetName: "app", Remediation: RemediationRestart}
+	// The next check before the pod is replaced reports nothing
+	for i := 0; i < 2; i++ {
+		require.NoError(t, as.Check(context.Background(), containers, kuberData))
+	}
+}
+
+func TestWatchMountPathAfterFailedCheck(t *testing.T) {
+	procRoot := t.TempDir()
+	dir := filepath.Join(procRoot, "etc", "nginx")
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	deploymentData := &models.DeploymentData{NamePod: "app-0", NameDeployment: "app", ContainerName: "nginx"}
+	container := &models.ContainerData{DeploymentData: deploymentData, ProcRoot: procRoot}
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+	r := mock_ports.NewMockIHashRepository(c)
+	checked := make(chan struct{}, 2)
+	// The database is unavailable for the first batch
+	first := r.EXPECT().GetHashData("etc/nginx", "SHA1", deploymentData).DoAndReturn(func(_, _ string, _ *models.DeploymentData) ([]*models.HashDataFromDB, error) {
+		checked <- struct{}{}
+		return nil, errors.New("connection refused")
+	}).Times(1)
+	r.EXPECT().GetHashData("etc/nginx", "SHA1", deploymentData).DoAndReturn(func(_, _ string, _ *models.DeploymentData) ([]*models.HashDataFromDB, error) {
+		select {
+		case checked <- struct{}{}:
+		default:
+		}
+		return nil, nil
+	}).After(first).MinTimes(1)
+
+	logger := logrus.New()
+	as := &AppService{IHashService: NewHashService(r, "SHA1", logger), logger: logger}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- as.watchMountPath(ctx, container, procRoot, models.MountPath{Path: "etc/nginx"}, 10*time.Millisecond, &models.KuberData{})
+	}()
+
+	for _, name := range []string{"a.conf", "b.conf"} {
+		// The watcher may start after the first file is written, so the file is written until it is checked
+		require.Eventually(t, func() bool {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600))
+			select {
+			case <-checked:
+				return true
+			case <-time.After(100 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, time.Millisecond, "%s is not checked", name)
+	}
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestHasBaseline(t *testing.T) {
+	deploymentData := &models.DeploymentData{NamePod: "app-0", NameDeployment: "app", ContainerName: "php"}
+	container := &models.ContainerData{
+		DeploymentData: deploymentData,
+		ConfigMapData:  &models.ConfigMapData{MountPaths: []models.MountPath{{Path: "usr/local/etc"}, {Path: "var/www"}}},
+	}
+	row := []*models.HashDataFromDB{{FileName: "index.php", FullFilePath: "/var/www/index.php", MountPath: "var/www"}}
+
+	testTable := []struct {
+		name          string
+		mockBehavior  func(r *mock_ports.MockIHashRepository)
+		expected      bool
+		expectedError bool
+	}{
+		{
+			name: "saved with the current algorithm",
+			mockBehavior: func(r *mock_ports.MockIHashRepository) {
+				r.EXPECT().GetHashData("usr/local/etc", "SHA256", deploymentData).Return(nil, nil)
+				r.EXPECT().GetHashData("var/www", "SHA256", deploymentData).Return(row, nil)
+			},
+			expected: true,
+		},
+		{
+			name: "saved with the previous algorithm",
+			mockBehavior: func(r *mock_ports.MockIHashRepository) {
+				r.EXPECT().GetHashData(gomock.Any(), "SHA256", deploymentData).Return(nil, nil).Times(2)
+				r.EXPECT().GetHashData("usr/local/etc", "SHA1", deploymentData).Return(row, nil)
+			},
+			expected: true,
+		},
+		{
+			name: "not saved for the container of the pod",
+			mockBehavior: func(r *mock_ports.MockIHashRepository) {
+				r.EXPECT().GetHashData(gomock.Any(), gomock.Any(), deploymentData).Return(nil, nil).Times(4)
+			},
+		},
+		{
+			name: "database error",
+			mockBehavior: func(r *mock_ports.MockIHashRepository) {
+				r.EXPECT().GetHashData("usr/local/etc", "SHA256", deploymentData).Return(nil, errors.New("connection refused"))
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			r := mock_ports.NewMockIHashRepository(c)
+			testCase.mockBehavior(r)
+			logger := logrus.New()
+			as := &AppService{IHashService: NewHashService(r, "SHA256", logger), previous: NewHashService(r, "SHA1", logger), logger: logger}
+
+			found, err := as.HasBaseline(container)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, found)
+		})
+	}
+}
+
+func TestLaunchHasher(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600))
+	}
+	as := &AppService{IHashService: NewHashService(nil, "SHA256", logrus.New()), logger: logrus.New()}
+
+	allHashData, err := as.LaunchHasher(context.Background(), dir, nil)
+	require.NoError(t, err)
+	assert.Len(t, allHashData, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = as.LaunchHasher(ctx, dir, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = as.LaunchHasher(context.Background(), filepath.Join(dir, "missing"), nil)
+	assert.Error(t, err)
+}
+
+func TestTrackProcess(t *testing.T) {
+	procDir := t.TempDir()
+	startProcess := func(pid, containerID string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(procDir, pid, "root"), 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "cgroup"), []byte("0::/kubepods/pod1/cri-containerd-"+containerID+".scope\n"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "stat"), []byte(pid+" (nginx) S"+strings.Repeat(" 0", 18)+" 100\n"), 0o600))
+	}
+	startProcess("7", "aaa")
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+	ks := mock_ports.NewMockIKuberService(c)
+	as := &AppService{IKuberService: ks, finder: process.NewFinder(procDir), logger: logrus.New()}
+	kuberData := &models.KuberData{}
+	container := &models.ContainerData{
+		DeploymentData: &models.DeploymentData{ContainerName: "nginx"},
+		ConfigMapData:  &models.ConfigMapData{ProcInContainer: true},
+		ContainerID:    "containerd://aaa",
+	}
+
+	// The first process found is not a restart
+	require.NoError(t, as.TrackProcess(container, kuberData))
+	assert.Equal(t, 7, container.PID)
+	assert.Equal(t, filepath.Join(procDir, "7", "root"), container.ProcRoot)
+
+	// The container restarted with a new ID
+	require.NoError(t, os.RemoveAll(filepath.Join(procDir, "7")))
+	startProcess("12", "bbb")
+	watchCanceled := false
+	as.watchCancels = map[*models.ContainerData]context.CancelFunc{container: func() { watchCanceled = true }}
+	ks.EXPECT().GetContainerID(kuberData, "nginx").Return("containerd://bbb", nil).Times(1)
+	ks.EXPECT().ReportProcessRestart(kuberData, gomock.Any()).DoAndReturn(func(_ *models.KuberData, restart *models.ProcessRestart) error {
+		assert.Equal(t, "nginx", restart.Container)
+		assert.Equal(t, 7, restart.OldPID)
+		assert.Equal(t, 12, restart.NewPID)
+		return nil
+	}).Times(1)
+	require.NoError(t, as.TrackProcess(container, kuberData))
+	assert.Equal(t, 12, container.PID)
+	assert.Equal(t, "containerd://bbb", container.ContainerID)
+	assert.Equal(t, filepath.Join(procDir, "12", "root"), container.ProcRoot)
+	assert.True(t, watchCanceled)
+
+	// The container is restarting
+	require.NoError(t, os.RemoveAll(filepath.Join(procDir, "12")))
+	ks.EXPECT().GetContainerID(kuberData, "nginx").Return("containerd://bbb", nil).Times(1)
+	assert.ErrorIs(t, as.TrackProcess(container, kuberData), ErrProcessNotFound)
+	assert.Equal(t, 12, container.PID)
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	procRoot := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "etc", "nginx"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "etc", "nginx", "nginx.conf"), []byte("abc"), 0o600))
+	deploymentData := &models.DeploymentData{NamePod: "app-0", NameDeployment: "app", ContainerName: "nginx"}
+	container := &models.ContainerData{DeploymentData: deploymentData, ProcRoot: procRoot}
+	mountPath := models.MountPath{Path: "etc/nginx"}
+
+	testTable := []struct {
+		name         string
+		previousHash string
+		migrated     bool
+	}{
+		{name: "unchanged files are migrated", previousHash: "a9993e364706816aba3e25717850c26c9cd0d89d", migrated: true},
+		{name: "changed files are not migrated", previousHash: "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			r := mock_ports.NewMockIHashRepository(c)
+			r.EXPECT().GetHashData("etc/nginx", "SHA1", deploymentData).Return([]*models.HashDataFromDB{
+				{FileName: "nginx.conf", FullFilePath: "/etc/nginx/nginx.conf", MountPath: "etc/nginx", Hash: testCase.previousHash, Algorithm: "SHA1"},
+			}, nil)
+			if testCase.migrated {
+				r.EXPECT().SaveHashData(gomock.Any(), deploymentData).DoAndReturn(func(hashData []*api.HashData, _ *models.DeploymentData) error {
+					require.Len(t, hashData, 1)
+					assert.Equal(t, "/etc/nginx/nginx.conf", hashData[0].FullFilePath)
+					assert.Equal(t, "SHA256", hashData[0].Algorithm)
+					return nil
+				}).Times(1)
+			}
+
+			logger := logrus.New()
+			as := &AppService{
+				IHashService:      NewHashService(r, "SHA256", logger),
+				previous:          NewHashService(r, "SHA1", logger),
+				previousAlgorithm: "SHA1",
+				logger:            logger,
+			}
+			hashData, err := as.hashMountPath(context.Background(), as.IHashService, procRoot, mountPath)
+			require.NoError(t, err)
+
+			report, baselineSize, err := as.migrateBaseline(context.Background(), container, mountPath, hashData)
+			require.NoError(t, err)
+			assert.Equal(t, 1, baselineSize)
+			assert.Equal(t, !testCase.migrated, report.IsChanged())
+		})
+	}
+}
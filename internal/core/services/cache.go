@@ -0,0 +1,100 @@
+package services
+
+import (
+	"os"
+	"sync"
+)
+
+// defaultCacheFullEvery is the number of checks after which all files are read again when HASH_CACHE_FULL_EVERY is not set
+const defaultCacheFullEvery = 10
+
+// fileStat identifies the version of a file, it changes with every write. The ctime can't be set back by the
+// owner of the file like the mtime, the periodic full re-read also covers a changed clock or a raw device write
+type fileStat struct {
+	dev   uint64
+	ino   uint64
+	size  int64
+	mtime int64
+	ctime int64
+}
+
+type cacheEntry struct {
+	stat  fileStat
+	hash  string
+	cycle int
+}
+
+// statCache remembers the hash sums of the files read by the periodic check, so a file with the same device,
+// inode, size, mtime and ctime is not read again. Every fullEvery checks all files are read and the entries replaced,
+// a full check which doesn't complete is repeated by the next one
+type statCache struct {
+	mu        sync.Mutex
+	fullEvery int
+	entries   map[string]cacheEntry
+	cycle     int
+	// lastFull is the last cycle which read all files and completed
+	lastFull int
+	full     bool
+	hits     int
+	misses   int
+}
+
+func newStatCache(fullEvery int) *statCache {
+	return &statCache{fullEvery: fullEvery, entries: make(map[string]cacheEntry)}
+}
+
+// begin starts a check and resets the counters. It returns true if the files are read regardless of the cache
+func (c *statCache) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cycle++
+	c.full = c.fullEvery > 0 && c.cycle-c.lastFull >= c.fullEvery
+	c.hits, c.misses = 0, 0
+	return c.full
+}
+
+// end finishes the check and returns its hits and misses. A completed check drops the entries of the files it didn't find,
+// the entries are kept when the check failed before all files were hashed
+func (c *statCache) end(completed bool) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !completed {
+		return c.hits, c.misses
+	}
+	if c.full {
+		c.lastFull = c.cycle
+	}
+	for name, entry := range c.entries {
+		if entry.cycle != c.cycle {
+			delete(c.entries, name)
+		}
+	}
+	return c.hits, c.misses
+}
+
+// lookup returns the hash sum of the file read before if its stat is unchanged
+func (c *statCache) lookup(name string, info os.FileInfo) (string, bool) {
+	stat, ok := statOf(info)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[name]
+	if !ok || !found || c.full || entry.stat != stat {
+		c.misses++
+		return "", false
+	}
+	entry.cycle = c.cycle
+	c.entries[name] = entry
+	c.hits++
+	return entry.hash, true
+}
+
+// store remembers the hash sum of the file read while its stat was unchanged
+func (c *statCache) store(name string, before, after os.FileInfo, hash string) {
+	stat, ok := statOf(before)
+	if current, same := statOf(after); !ok || !same || current != stat {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = cacheEntry{stat: stat, hash: hash, cycle: c.cycle}
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"os"
+	"syscall"
+)
+
+// statOf returns the stat of the file the cache compares
+func statOf(info os.FileInfo) (fileStat, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		dev:   uint64(stat.Dev),
+		ino:   uint64(stat.Ino),
+		size:  stat.Size,
+		mtime: stat.Mtim.Nano(),
+		ctime: stat.Ctim.Nano(),
+	}, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package services
+
+import "os"
+
+// statOf reports that the stat is unknown, the ctime is read only on Linux, so every file is read
+func statOf(os.FileInfo) (fileStat, bool) {
+	return fileStat{}, false
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/integrity-sum/pkg/api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatCache(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.conf")
+	require.NoError(t, os.WriteFile(name, []byte("old"), 0o600))
+
+	cache := newStatCache(3)
+	hs := NewHashService(nil, "SHA256", logrus.New())
+	job := api.Job{Path: name, Content: name}
+	check := func() (string, int, int) {
+		cache.begin()
+		data, err := hs.createHash(context.Background(), job, cache)
+		require.NoError(t, err)
+		hits, misses := cache.end(true)
+		return data.Hash, hits, misses
+	}
+
+	oldHash, hits, misses := check()
+	assert.Equal(t, []int{0, 1}, []int{hits, misses})
+	hash, hits, misses := check()
+	assert.Equal(t, oldHash, hash)
+	assert.Equal(t, []int{1, 0}, []int{hits, misses})
+
+	// The third check reads every file
+	_, hits, misses = check()
+	assert.Equal(t, []int{0, 1}, []int{hits, misses})
+
+	// A file written with the same size and mtime still has a new ctime
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(name, []byte("new"), 0o600))
+	require.NoError(t, os.Chtimes(name, info.ModTime(), info.ModTime()))
+	hash, hits, misses = check()
+	assert.NotEqual(t, oldHash, hash)
+	assert.Equal(t, []int{0, 1}, []int{hits, misses})
+
+	// A full check which failed is repeated by the next one, the entries are kept
+	cache = newStatCache(2)
+	check()
+	assert.True(t, cache.begin())
+	cache.end(false)
+	assert.Len(t, cache.entries, 1)
+	assert.True(t, cache.begin())
+	cache.end(true)
+	assert.False(t, cache.begin())
+	cache.end(true)
+
+	// The entries of the files not found by a completed check are dropped
+	assert.Empty(t, cache.entries)
+}
+
+func TestCachedHashService(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600))
+	}
+	as := &AppService{IHashService: NewHashService(nil, "SHA256", logrus.New()), cache: newStatCache(0), logger: logrus.New()}
+
+	// The files hashed for the baseline or the watcher between begin and end are not counted or stored
+	as.cache.begin()
+	_, err := as.LaunchHasher(context.Background(), dir, nil)
+	require.NoError(t, err)
+	_, err = as.IHashService.CreateHash(api.Job{Path: filepath.Join(dir, "a.txt"), Content: filepath.Join(dir, "a.txt")})
+	require.NoError(t, err)
+	hits, misses := as.cache.end(true)
+	assert.Equal(t, []int{0, 0}, []int{hits, misses})
+	assert.Empty(t, as.cache.entries)
+
+	for _, expected := range [][]int{{0, 2}, {2, 0}} {
+		as.cache.begin()
+		_, err = as.launchHasher(context.Background(), as.cachedHashService(), "", dir, nil)
+		require.NoError(t, err)
+		hits, misses = as.cache.end(true)
+		assert.Equal(t, expected, []int{hits, misses})
+	}
+}
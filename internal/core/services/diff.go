@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/pkg/api"
+)
+
+// CompareHashData compares the current hash data with the data stored in the database
+// and collects every difference into a report instead of stopping at the first one
+func CompareHashData(currentHashData []*api.HashData, hashDataFromDB []*models.HashDataFromDB, deploymentData *models.DeploymentData) *models.DiffReport {
+	report := &models.DiffReport{}
+
+	currentByPath := make(map[string]*api.HashData, len(currentHashData))
+	for _, dataCurrent := range currentHashData {
+		currentByPath[dataCurrent.FullFilePath] = dataCurrent
+	}
+
+	fromDBByPath := make(map[string]struct{}, len(hashDataFromDB))
+	for _, dataFromDB := range hashDataFromDB {
+		fromDBByPath[dataFromDB.FullFilePath] = struct{}{}
+
+		dataCurrent, ok := currentByPath[dataFromDB.FullFilePath]
+		if !ok || dataCurrent.Algorithm != dataFromDB.Algorithm {
+			report.Changes = append(report.Changes, &models.FileChange{
+				Type:         models.ChangeDeleted,
+				FileName:     dataFromDB.FileName,
+				FullFilePath: dataFromDB.FullFilePath,
+				OldHash:      dataFromDB.Hash,
+			})
+			continue
+		}
+
+		if reasons := compareAttributes(dataFromDB, dataCurrent); len(reasons) > 0 {
+			report.Changes = append(report.Changes, &models.FileChange{
+				Type:         models.ChangeModified,
+				FileName:     dataFromDB.FileName,
+				FullFilePath: dataFromDB.FullFilePath,
+				OldHash:      dataFromDB.Hash,
+				NewHash:      dataCurrent.Hash,
+				Reasons:      reasons,
+			})
+			continue
+		}
+
+		if dataFromDB.ImageContainer != deploymentData.Image && dataFromDB.NameDeployment == deploymentData.NameDeployment {
+			report.Changes = append(report.Changes, &models.FileChange{
+				Type:         models.ChangeImageChanged,
+				FileName:     dataFromDB.FileName,
+				FullFilePath: dataFromDB.FullFilePath,
+				OldHash:      dataFromDB.Hash,
+				NewHash:      dataCurrent.Hash,
+				OldImage:     dataFromDB.ImageContainer,
+				NewImage:     deploymentData.Image,
+			})
+		}
+	}
+
+	for _, dataCurrent := range currentHashData {
+		if _, ok := fromDBByPath[dataCurrent.FullFilePath]; !ok {
+			report.Changes = append(report.Changes, &models.FileChange{
+				Type:         models.ChangeAdded,
+				FileName:     dataCurrent.FileName,
+				FullFilePath: dataCurrent.FullFilePath,
+				NewHash:      dataCurrent.Hash,
+			})
+		}
+	}
+
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		return report.Changes[i].FullFilePath < report.Changes[j].FullFilePath
+	})
+
+	return report
+}
+
+// compareAttributes returns the attributes of the file which differ from the baseline.
+// The metadata is compared only when the baseline recorded it
+func compareAttributes(dataFromDB *models.HashDataFromDB, dataCurrent *api.HashData) []models.AttributeChange {
+	var reasons []models.AttributeChange
+	add := func(attribute, old, current string) {
+		if old != current {
+			reasons = append(reasons, models.AttributeChange{Attribute: attribute, Old: old, New: current})
+		}
+	}
+	add(models.AttributeContent, dataFromDB.Hash, dataCurrent.Hash)
+	if dataFromDB.Type == "" {
+		return reasons
+	}
+
+	old, current := dataFromDB.Metadata, dataCurrent.Metadata
+	add(models.AttributeType, old.Type, current.Type)
+	add(models.AttributeMode, fmt.Sprintf("%04o", old.Mode), fmt.Sprintf("%04o", current.Mode))
+	add(models.AttributeUID, strconv.Itoa(old.UID), strconv.Itoa(current.UID))
+	add(models.AttributeGID, strconv.Itoa(old.GID), strconv.Itoa(current.GID))
+	add(models.AttributeSize, strconv.FormatInt(old.Size, 10), strconv.FormatInt(current.Size, 10))
+	add(models.AttributeLinkTarget, old.LinkTarget, current.LinkTarget)
+	return reasons
+}
+
+// comparableHashData drops the directories from the current data when the baseline was saved before
+// the metadata was recorded, such a baseline holds no directories and is compared by content only
+func comparableHashData(currentHashData []*api.HashData, hashDataFromDB []*models.HashDataFromDB) []*api.HashData {
+	if len(hashDataFromDB) == 0 {
+		return currentHashData
+	}
+	for _, dataFromDB := range hashDataFromDB {
+		if dataFromDB.Type != "" {
+			return currentHashData
+		}
+	}
+	result := make([]*api.HashData, 0, len(currentHashData))
+	for _, dataCurrent := range currentHashData {
+		if dataCurrent.Type != api.FileTypeDir {
+			result = append(result, dataCurrent)
+		}
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareHashData(t *testing.T) {
+	deploymentData := &models.DeploymentData{
+		Image:          "nginx:latest",
+		NamePod:        "app-nginx-hasher-integrity-6b64487565-l8ltd",
+		NameDeployment: "app-nginx-hasher-integrity",
+	}
+	fromDB := func(path, hash, image string) *models.HashDataFromDB {
+		return &models.HashDataFromDB{
+			Hash:           hash,
+			FileName:       path,
+			FullFilePath:   "test/" + path,
+			Algorithm:      "SHA256",
+			ImageContainer: image,
+			NamePod:        deploymentData.NamePod,
+			NameDeployment: deploymentData.NameDeployment,
+		}
+	}
+	current := func(path, hash string) *api.HashData {
+		return &api.HashData{
+			Hash:         hash,
+			FileName:     path,
+			FullFilePath: "test/" + path,
+			Algorithm:    "SHA256",
+		}
+	}
+
+	setuidFromDB, setuidCurrent := fromDB("nginx", "1", "nginx:latest"), current("nginx", "1")
+	setuidFromDB.Metadata = api.Metadata{Type: api.FileTypeRegular, Mode: 0o755, Size: 10}
+	setuidCurrent.Metadata = api.Metadata{Type: api.FileTypeRegular, Mode: 0o4755, UID: 1000, Size: 10}
+	linkFromDB, linkCurrent := fromDB("link", "1", "nginx:latest"), current("link", "1")
+	linkFromDB.Metadata = api.Metadata{Type: api.FileTypeSymlink, Size: 1, LinkTarget: "a"}
+	linkCurrent.Metadata = api.Metadata{Type: api.FileTypeSymlink, Size: 1, LinkTarget: "b"}
+	legacyCurrent := current("a.txt", "1")
+	legacyCurrent.Metadata = api.Metadata{Type: api.FileTypeRegular, Mode: 0o644, Size: 10}
+
+	testTable := []struct {
+		name            string
+		currentHashData []*api.HashData
+		hashDataFromDB  []*models.HashDataFromDB
+		expected        []*models.FileChange
+	}{
+		{
+			name:            "the current data and the data in the database are the same",
+			currentHashData: []*api.HashData{current("a.txt", "1"), current("b.txt", "2")},
+			hashDataFromDB:  []*models.HashDataFromDB{fromDB("b.txt", "2", "nginx:latest"), fromDB("a.txt", "1", "nginx:latest")},
+		},
+		{
+			name:            "all differences are reported",
+			currentHashData: []*api.HashData{current("a.txt", "1"), current("b.txt", "3"), current("d.txt", "4")},
+			hashDataFromDB:  []*models.HashDataFromDB{fromDB("a.txt", "1", "nginx:latest"), fromDB("b.txt", "2", "nginx:latest"), fromDB("c.txt", "5", "nginx:latest")},
+			expected: []*models.FileChange{
+				{Type: models.ChangeModified, FileName: "b.txt", FullFilePath: "test/b.txt", OldHash: "2", NewHash: "3", Reasons: []models.AttributeChange{
+					{Attribute: models.AttributeContent, Old: "2", New: "3"},
+				}},
+				{Type: models.ChangeDeleted, FileName: "c.txt", FullFilePath: "test/c.txt", OldHash: "5"},
+				{Type: models.ChangeAdded, FileName: "d.txt", FullFilePath: "test/d.txt", NewHash: "4"},
+			},
+		},
+		{
+			name:            "mode and owner are compared",
+			currentHashData: []*api.HashData{setuidCurrent},
+			hashDataFromDB:  []*models.HashDataFromDB{setuidFromDB},
+			expected: []*models.FileChange{
+				{Type: models.ChangeModified, FileName: "nginx", FullFilePath: "test/nginx", OldHash: "1", NewHash: "1", Reasons: []models.AttributeChange{
+					{Attribute: models.AttributeMode, Old: "0755", New: "4755"},
+					{Attribute: models.AttributeUID, Old: "0", New: "1000"},
+				}},
+			},
+		},
+		{
+			name:            "target of a symlink is compared",
+			currentHashData: []*api.HashData{linkCurrent},
+			hashDataFromDB:  []*models.HashDataFromDB{linkFromDB},
+			expected: []*models.FileChange{
+				{Type: models.ChangeModified, FileName: "link", FullFilePath: "test/link", OldHash: "1", NewHash: "1", Reasons: []models.AttributeChange{
+					{Attribute: models.AttributeLinkTarget, Old: "a", New: "b"},
+				}},
+			},
+		},
+		{
+			name:            "a baseline without metadata is compared by content",
+			currentHashData: []*api.HashData{legacyCurrent},
+			hashDataFromDB:  []*models.HashDataFromDB{fromDB("a.txt", "1", "nginx:latest")},
+		},
+		{
+			name:            "image of the container was changed",
+			currentHashData: []*api.HashData{current("a.txt", "1")},
+			hashDataFromDB:  []*models.HashDataFromDB{fromDB("a.txt", "1", "nginx:1.21")},
+			expected: []*models.FileChange{
+				{Type: models.ChangeImageChanged, FileName: "a.txt", FullFilePath: "test/a.txt", OldHash: "1", NewHash: "1", OldImage: "nginx:1.21", NewImage: "nginx:latest"},
+			},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			report := CompareHashData(testCase.currentHashData, testCase.hashDataFromDB, deploymentData)
+
+			assert.Equal(t, len(testCase.expected) > 0, report.IsChanged())
+			assert.Equal(t, testCase.expected, report.Changes)
+		})
+	}
+}
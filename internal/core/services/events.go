@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/integrity-sum/internal/core/models"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// EventReasonIntegrityViolation is the reason of the events emitted when a check found differences
+	EventReasonIntegrityViolation = "IntegrityViolation"
+	// EventReasonProcessRestarted is the reason of the events emitted when the monitored process was replaced
+	EventReasonProcessRestarted = "MonitoredProcessRestarted"
+	eventComponent              = "integrity-sum"
+	// maxEventMessage is the length of the message accepted by the API server
+	maxEventMessage = 1024
+)
+
+// Annotations of the pod with the result of the last check
+const (
+	AnnotationLastScanTime   = "integrity-sum/last-scan-time"
+	AnnotationLastScanResult = "integrity-sum/last-scan-result"
+	AnnotationChangedFiles   = "integrity-sum/changed-files"
+)
+
+// Results of a check recorded in the AnnotationLastScanResult annotation
+const (
+	ScanResultClean     = "clean"
+	ScanResultViolation = "violation"
+)
+
+// emitViolationEvents creates a Warning event for the pod and one for the target workload
+func (ks *KuberService) emitViolationEvents(kuberData *models.KuberData, violation *models.Violation) error {
+	message := violationMessage(violation)
+	objects := []models.WorkloadIdentity{kuberData.Pod}
+	if kuberData.Target.Name != "" {
+		objects = append(objects, kuberData.Target)
+	}
+
+	for _, object := range objects {
+		if err := createEvent(kuberData, object, EventReasonIntegrityViolation, corev1.EventTypeWarning, message, violation.DetectedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitProcessRestartedEvent creates a Normal event for the pod, a restarted process is not a violation
+// as long as the next check finds the files unchanged
+func (ks *KuberService) emitProcessRestartedEvent(kuberData *models.KuberData, restart *models.ProcessRestart) error {
+	message := fmt.Sprintf("process of the container %s was replaced, pid %d -> %d", restart.Container, restart.OldPID, restart.NewPID)
+	return createEvent(kuberData, kuberData.Pod, EventReasonProcessRestarted, corev1.EventTypeNormal, message, restart.DetectedAt)
+}
+
+func createEvent(kuberData *models.KuberData, object models.WorkloadIdentity, reason, eventType, message string, at time.Time) error {
+	now := metav1.NewTime(at)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", object.Name, time.Now().UnixNano()),
+			Namespace: kuberData.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: object.APIVersion,
+			Kind:       object.Kind,
+			Namespace:  kuberData.Namespace,
+			Name:       object.Name,
+			UID:        types.UID(object.UID),
+		},
+		Reason:              reason,
+		Message:             message,
+		Type:                eventType,
+		Source:              corev1.EventSource{Component: eventComponent},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: eventComponent,
+		ReportingInstance:   kuberData.Pod.Name,
+	}
+	_, err := kuberData.Clientset.CoreV1().Events(kuberData.Namespace).Create(context.Background(), event, metav1.CreateOptions{})
+	return err
+}
+
+// annotateScanResult records the time, the result and the number of changed files of the last check on the pod
+func (ks *KuberService) annotateScanResult(kuberData *models.KuberData, changedFiles int) error {
+	result := ScanResultClean
+	if changedFiles > 0 {
+		result = ScanResultViolation
+	}
+	patchData, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				AnnotationLastScanTime:   time.Now().UTC().Format(time.RFC3339),
+				AnnotationLastScanResult: result,
+				AnnotationChangedFiles:   strconv.Itoa(changedFiles),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = kuberData.Clientset.CoreV1().Pods(kuberData.Namespace).Patch(context.Background(), kuberData.Pod.Name,
+		types.MergePatchType, patchData, metav1.PatchOptions{})
+	return err
+}
+
+// violationMessage summarizes the violation and lists as many changed paths as fit into an event message
+func violationMessage(violation *models.Violation) string {
+	report := violation.Report
+	var b strings.Builder
+	fmt.Fprintf(&b, "Integrity check found %d changes (added %d, deleted %d, modified %d, image changed %d), remediation %s:",
+		len(report.Changes), report.Count(models.ChangeAdded), report.Count(models.ChangeDeleted),
+		report.Count(models.ChangeModified), report.Count(models.ChangeImageChanged), violation.Remediation)
+	for i, change := range report.Changes {
+		item := fmt.Sprintf(" %s %s", change.Type, change.FullFilePath)
+		more := fmt.Sprintf(" and %d more", len(report.Changes)-i)
+		if b.Len()+len(item)+len(",")+len(more) > maxEventMessage {
+			b.WriteString(more)
+			break
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(item)
+	}
+	return b.String()
+}
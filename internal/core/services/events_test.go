@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReportViolation(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: objectMeta("app-0", "pod-uid", nil)})
+	kuberData := &models.KuberData{
+		Clientset: clientset,
+		Namespace: "default",
+		Pod:       models.WorkloadIdentity{APIVersion: "v1", Kind: "Pod", Name: "app-0", UID: "pod-uid"},
+		Target:    models.WorkloadIdentity{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "app", UID: "sts-uid"},
+	}
+	ks := NewKuberService(logrus.New())
+
+	err := ks.ReportViolation(kuberData, &models.Violation{
+		DetectedAt:  time.Now(),
+		Remediation: RemediationRestart,
+		Report: &models.DiffReport{Changes: []*models.FileChange{
+			{Type: models.ChangeModified, FullFilePath: "/etc/nginx/nginx.conf"},
+		}},
+	})
+	require.NoError(t, err)
+
+	events, err := clientset.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 2)
+	kinds := make([]string, 0, len(events.Items))
+	for _, event := range events.Items {
+		assert.Equal(t, corev1.EventTypeWarning, event.Type)
+		assert.Equal(t, EventReasonIntegrityViolation, event.Reason)
+		assert.Contains(t, event.Message, "modified /etc/nginx/nginx.conf")
+		kinds = append(kinds, event.InvolvedObject.Kind)
+	}
+	assert.ElementsMatch(t, []string{"Pod", "StatefulSet"}, kinds)
+
+	require.NoError(t, ks.AnnotateScanResult(kuberData, 1))
+	pod, err := clientset.CoreV1().Pods("default").Get(context.Background(), "app-0", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ScanResultViolation, pod.Annotations[AnnotationLastScanResult])
+	assert.Equal(t, "1", pod.Annotations[AnnotationChangedFiles])
+	assert.NotEmpty(t, pod.Annotations[AnnotationLastScanTime])
+}
+
+func TestViolationMessage(t *testing.T) {
+	report := &models.DiffReport{}
+	for i := 0; i < 100; i++ {
+		report.Changes = append(report.Changes, &models.FileChange{Type: models.ChangeAdded, FullFilePath: "/etc/nginx/conf.d/" + strings.Repeat("x", 20)})
+	}
+
+	message := violationMessage(&models.Violation{Remediation: RemediationAlert, Report: report})
+	assert.LessOrEqual(t, len(message), maxEventMessage)
+	assert.True(t, strings.HasPrefix(message, "Integrity check found 100 changes (added 100"))
+	assert.Contains(t, message, "more")
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,15 +14,20 @@ import (
 
 	"github.com/integrity-sum/internal/core/models"
 	"github.com/integrity-sum/internal/core/ports"
+	"github.com/integrity-sum/internal/metrics"
 	"github.com/integrity-sum/pkg/api"
 	"github.com/integrity-sum/pkg/hasher"
 	"github.com/sirupsen/logrus"
 )
 
+var errNotRegular = errors.New("not a regular file")
+
 type HashService struct {
 	hashRepository ports.IHashRepository
 	alg            string
-	logger         *logrus.Logger
+	// cache skips reading the unchanged files found by the workers, set only for the periodic check
+	cache  *statCache
+	logger *logrus.Logger
 }
 
 // NewHashService creates a new struct HashService
@@ -32,8 +39,8 @@ func NewHashService(hashRepository ports.IHashRepository, alg string, logger *lo
 	}
 }
 
-// WorkerPool launches a certain number of workers for concurrent processing
-func (hs HashService) WorkerPool(jobs chan string, results chan *api.HashData) {
+// WorkerPool launches a certain number of workers for concurrent processing and closes results when they stop
+func (hs HashService) WorkerPool(ctx context.Context, jobs <-chan api.Job, results chan<- *api.HashData) {
 	countWorkers, err := strconv.Atoi(os.Getenv("COUNT_WORKERS"))
 	if err != nil {
 		countWorkers = runtime.NumCPU()
@@ -42,30 +49,85 @@ func (hs HashService) WorkerPool(jobs chan string, results chan *api.HashData) {
 	var wg sync.WaitGroup
 	for w := 1; w <= countWorkers; w++ {
 		wg.Add(1)
-		go hs.Worker(&wg, jobs, results)
+		go hs.Worker(ctx, &wg, jobs, results)
 	}
 	defer close(results)
 	wg.Wait()
 }
 
-// Worker gets jobs from a pipe and writes the result to stdout and database
-func (hs HashService) Worker(wg *sync.WaitGroup, jobs <-chan string, results chan<- *api.HashData) {
+// Worker gets jobs from a pipe and sends the hash sums to results until jobs is closed or the context is done
+func (hs HashService) Worker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan api.Job, results chan<- *api.HashData) {
 	defer wg.Done()
-	for j := range jobs {
-		data, err := hs.CreateHash(j)
+	for {
+		var j api.Job
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobs:
+			if !ok {
+				return
+			}
+			j = job
+		}
+
+		data, err := hs.createHash(ctx, j, hs.cache)
 		if err != nil {
-			hs.logger.Errorf("error creating file hash - %s, %s", j, err)
+			if ctx.Err() != nil {
+				return
+			}
+			metrics.IncHashErrors()
+			hs.logger.Errorf("error creating file hash - %s, %s", j.Path, err)
 			continue
 		}
-		results <- data
+		select {
+		case results <- data:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-// CreateHash creates a new object with a hash sum
-func (hs HashService) CreateHash(path string) (*api.HashData, error) {
-	file, err := os.Open(path)
+// CreateHash creates a new object with the metadata of the file and the hash sum of its content.
+// The file is always read, it is called for the files the watcher found changed
+func (hs HashService) CreateHash(job api.Job) (*api.HashData, error) {
+	return hs.createHash(context.Background(), job, nil)
+}
+
+// contextReader stops reading when the context is done, so hashing of a large file is interrupted
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// createHash takes the hash sum of an unchanged file from the cache if it is not nil
+func (hs HashService) createHash(ctx context.Context, job api.Job, cache *statCache) (*api.HashData, error) {
+	path := job.Path
+	metadata, err := api.ReadMetadata(path)
 	if err != nil {
-		hs.logger.Errorf("can not open file %s %s", path, err)
+		hs.logger.Errorf("can not read metadata of %s %s", path, err)
+		return nil, err
+	}
+	outputHashSum := &api.HashData{
+		FileName:     filepath.Base(path),
+		FullFilePath: path,
+		Algorithm:    hs.alg,
+		Metadata:     metadata,
+	}
+	// Directories, special files and symlinks which are not followed are recorded by their metadata only
+	if job.Content == "" || metadata.Type == api.FileTypeDir || metadata.Type == api.FileTypeOther {
+		return outputHashSum, nil
+	}
+
+	file, info, err := openRegular(job.Root, job.Content)
+	if err != nil {
+		hs.logger.Errorf("can not open file %s %s", job.Content, err)
 		return nil, err
 	}
 	defer func(file *os.File) {
@@ -75,35 +137,61 @@ func (hs HashService) CreateHash(path string) (*api.HashData, error) {
 		}
 	}(file)
 
-	h := hasher.NewHashSum(hs.alg)
-	_, err = io.Copy(h, file)
+	if cache != nil {
+		if hash, ok := cache.lookup(job.Content, info); ok {
+			outputHashSum.Hash = hash
+			return outputHashSum, nil
+		}
+	}
+
+	h, err := hasher.NewHashSum(hs.alg)
 	if err != nil {
 		return nil, err
 	}
-	res := hex.EncodeToString(h.Sum(nil))
-	outputHashSum := api.HashData{
-		Hash:         res,
-		FileName:     filepath.Base(path),
-		FullFilePath: path,
-		Algorithm:    hs.alg,
+	size, err := io.Copy(h, contextReader{ctx: ctx, r: file})
+	if err != nil {
+		return nil, err
+	}
+	metrics.AddHashedFile(size)
+	outputHashSum.Hash = hex.EncodeToString(h.Sum(nil))
+	if cache != nil {
+		// A file written while it was read is read again by the next check
+		if after, err := file.Stat(); err == nil {
+			cache.store(job.Content, info, after, outputHashSum.Hash)
+		}
 	}
-	return &outputHashSum, nil
+	return outputHashSum, nil
+}
+
+// regularFile returns the opened file only if it is a regular file, otherwise it closes it
+func regularFile(file *os.File, name string) (*os.File, os.FileInfo, error) {
+	info, err := file.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("%s: %w", name, errNotRegular)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
 }
 
 // SaveHashData accesses the repository to save data to the database
 func (hs HashService) SaveHashData(allHashData []*api.HashData, deploymentData *models.DeploymentData) error {
 	err := hs.hashRepository.SaveHashData(allHashData, deploymentData)
 	if err != nil {
+		metrics.IncDatabaseErrors("save_hash_data")
 		hs.logger.Error("error while saving data to database", err)
 		return err
 	}
 	return nil
 }
 
-// GetHashData accesses the repository to get data from the database
-func (hs HashService) GetHashData(dirFiles string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error) {
-	hashData, err := hs.hashRepository.GetHashData(dirFiles, hs.alg, deploymentData)
+// GetHashData accesses the repository to get the baseline of the mount path from the database
+func (hs HashService) GetHashData(mountPath string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error) {
+	hashData, err := hs.hashRepository.GetHashData(mountPath, hs.alg, deploymentData)
 	if err != nil {
+		metrics.IncDatabaseErrors("get_hash_data")
 		hs.logger.Error("hashData service didn't get hashData sum", err)
 		return nil, err
 	}
@@ -113,63 +201,63 @@ func (hs HashService) GetHashData(dirFiles string, deploymentData *models.Deploy
 func (hs HashService) DeleteFromTable(nameDeployment string) error {
 	err := hs.hashRepository.DeleteFromTable(nameDeployment)
 	if err != nil {
+		metrics.IncDatabaseErrors("delete_hash_data")
 		hs.logger.Error("err while deleting rows in database", err)
 		return err
 	}
 	return nil
 }
 
-// IsDataChanged checks if the current data has changed with the data stored in the database
-func (hs HashService) IsDataChanged(currentHashData []*api.HashData, hashDataFromDB []*models.HashDataFromDB, deploymentData *models.DeploymentData) bool {
-	isDataChanged := wasDataChanged(hashDataFromDB, currentHashData, deploymentData)
-	isAddedFiles := wasAddedFiles(currentHashData, hashDataFromDB)
-
-	if isDataChanged || isAddedFiles {
-		return true
-	}
-	return false
-}
-
-func wasDataChanged(hashSumFromDB []*models.HashDataFromDB, currentHashData []*api.HashData, deploymentData *models.DeploymentData) bool {
-	for _, dataFromDB := range hashSumFromDB {
-		trigger := false
-		for _, dataCurrent := range currentHashData {
-			if dataFromDB.FullFilePath == dataCurrent.FullFilePath && dataFromDB.Algorithm == dataCurrent.Algorithm {
-				if dataFromDB.Hash != dataCurrent.Hash {
-					fmt.Printf("Changed: file - %s the path %s, old hash sum %s, new hash sum %s\n",
-						dataFromDB.FileName, dataFromDB.FullFilePath, dataFromDB.Hash, dataCurrent.Hash)
-					return true
-				}
-				if dataFromDB.ImageContainer != deploymentData.Image && dataFromDB.NameDeployment == deploymentData.NameDeployment {
-					fmt.Printf("Changed image container: file - %s the path %s, old image %s, new image %s\n",
-						dataFromDB.FileName, dataFromDB.FullFilePath, dataFromDB.ImageContainer, deploymentData.Image)
-					return true
-				}
-				trigger = true
-				break
-			}
-		}
+// DeleteOtherAlgorithms accesses the repository to remove the baseline of the container saved with other algorithms
+func (hs HashService) DeleteOtherAlgorithms(deploymentData *models.DeploymentData) error {
+	err := hs.hashRepository.DeleteOtherAlgorithms(hs.alg, deploymentData)
+	if err != nil {
+		metrics.IncDatabaseErrors("delete_other_algorithms")
+		hs.logger.Error("err while deleting rows in database", err)
+		return err
+	}
+	return nil
+}
 
-		if !trigger {
-			fmt.Printf("Deleted: file - %s the path %s hash sum %s\n", dataFromDB.FileName, dataFromDB.FullFilePath, dataFromDB.Hash)
-			return true
-		}
+// SaveImageBaseline accesses the repository to save the baseline computed from an image
+func (hs HashService) SaveImageBaseline(imageDigest, imageName string, allHashData []*api.HashData) error {
+	err := hs.hashRepository.SaveImageBaseline(imageDigest, imageName, allHashData)
+	if err != nil {
+		metrics.IncDatabaseErrors("save_image_baseline")
+		hs.logger.Error("error while saving image baseline to database", err)
+		return err
 	}
-	return false
+	return nil
 }
 
-func wasAddedFiles(currentHashData []*api.HashData, hashDataFromDB []*models.HashDataFromDB) bool {
-	dataFromDB := make(map[string]struct{}, len(hashDataFromDB))
-	for _, value := range hashDataFromDB {
-		dataFromDB[value.FullFilePath] = struct{}{}
+// GetImageBaseline accesses the repository to get the baseline computed from an image
+func (hs HashService) GetImageBaseline(imageDigest string) ([]*models.HashDataFromDB, error) {
+	hashData, err := hs.hashRepository.GetImageBaseline(imageDigest, hs.alg)
+	if err != nil {
+		metrics.IncDatabaseErrors("get_image_baseline")
+		hs.logger.Error("hashData service didn't get image baseline", err)
+		return nil, err
 	}
+	return hashData, nil
+}
 
-	for _, dataCurrent := range currentHashData {
-		if _, ok := dataFromDB[dataCurrent.FullFilePath]; !ok {
-			fmt.Printf("Changed: the current data is different from the data in the database, current file - %s the path %s hash sum %s\n",
-				dataCurrent.FileName, dataCurrent.FullFilePath, dataCurrent.Hash)
-			return true
-		}
+// SaveViolation accesses the repository to record the differences found by a check
+func (hs HashService) SaveViolation(violation *models.Violation) error {
+	err := hs.hashRepository.SaveViolation(violation)
+	if err != nil {
+		metrics.IncDatabaseErrors("save_violation")
+		hs.logger.Error("hashData service didn't save violation", err)
+		return err
 	}
-	return false
+	return nil
+}
+
+// IsDataChanged checks if the current data has changed with the data stored in the database
+func (hs HashService) IsDataChanged(currentHashData []*api.HashData, hashDataFromDB []*models.HashDataFromDB, deploymentData *models.DeploymentData) bool {
+	return hs.Diff(currentHashData, hashDataFromDB, deploymentData).IsChanged()
+}
+
+// Diff returns a report with all differences between the current data and the data stored in the database
+func (hs HashService) Diff(currentHashData []*api.HashData, hashDataFromDB []*models.HashDataFromDB, deploymentData *models.DeploymentData) *models.DiffReport {
+	return CompareHashData(currentHashData, hashDataFromDB, deploymentData)
 }
@@ -26,7 +26,7 @@ func TestCreateHash(t *testing.T) {
 			alg:  "SHA256",
 			path: "test/test.txt",
 			mockBehavior: func(s *mock_ports.MockIHashService, path string) {
-				s.EXPECT().CreateHash(path).Return(&api.HashData{
+				s.EXPECT().CreateHash(api.Job{Path: path, Content: path}).Return(&api.HashData{
 					Hash:         "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 					FileName:     "test.txt",
 					FullFilePath: "test/test.txt",
@@ -46,7 +46,7 @@ func TestCreateHash(t *testing.T) {
 			alg:  "SHA256",
 			path: "test/h",
 			mockBehavior: func(s *mock_ports.MockIHashService, path string) {
-				s.EXPECT().CreateHash(path).Return(&api.HashData{
+				s.EXPECT().CreateHash(api.Job{Path: path, Content: path}).Return(&api.HashData{
 					Hash:         "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 					FileName:     "test2.txt",
 					FullFilePath: "test/h",
@@ -66,7 +66,7 @@ func TestCreateHash(t *testing.T) {
 			alg:  "SHA1",
 			path: "test/test.txt",
 			mockBehavior: func(s *mock_ports.MockIHashService, path string) {
-				s.EXPECT().CreateHash(path).Return(&api.HashData{
+				s.EXPECT().CreateHash(api.Job{Path: path, Content: path}).Return(&api.HashData{
 					Hash:         "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 					FileName:     "test.txt",
 					FullFilePath: "test/test.txt",
@@ -86,7 +86,7 @@ func TestCreateHash(t *testing.T) {
 			alg:  "SHA256",
 			path: "/h/new.txt",
 			mockBehavior: func(s *mock_ports.MockIHashService, path string) {
-				s.EXPECT().CreateHash(path).Return(nil, errors.New("do not exist file path"))
+				s.EXPECT().CreateHash(api.Job{Path: path, Content: path}).Return(nil, errors.New("do not exist file path"))
 
 			},
 			expectedError: true,
@@ -107,7 +107,7 @@ func TestCreateHash(t *testing.T) {
 			}
 			defer file.Close()
 
-			result, err := service.CreateHash(testCase.path)
+			result, err := service.CreateHash(api.Job{Path: testCase.path, Content: testCase.path})
 
 			if testCase.expectedError {
 				assert.Error(t, err)
@@ -2,15 +2,19 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/integrity-sum/internal/configs"
 	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/pkg/image"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -44,10 +48,54 @@ func (ks *KuberService) GetDataFromK8sAPI() (*models.DataFromK8sAPI, error) {
 		return &models.DataFromK8sAPI{}, err
 	}
 
+	// Every container gets a copy of the data of the workload with its own image, so its baseline is stored separately
+	var containers []*models.ContainerData
+	for _, container := range deploymentData.Containers {
+		containerConfig, ok := configData[container.Name]
+		if !ok {
+			continue
+		}
+		containerDeploymentData := *deploymentData
+		containerDeploymentData.ContainerName = container.Name
+		containerDeploymentData.Image = container.Image
+		containerDeploymentData.ImageDigest = container.ImageDigest
+		containers = append(containers, &models.ContainerData{
+			DeploymentData: &containerDeploymentData,
+			ConfigMapData:  containerConfig,
+			ContainerID:    container.ContainerID,
+		})
+		ks.logger.Infof("### 📦 Container %v runs %v, monitoring process %v", container.Name, container.Image, containerConfig.ProcName)
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("%w: the ConfigMap has no entry for any container of the pod", configs.ErrInvalidConfig)
+	}
+
+	// The reaction on a violation applies to the whole workload, so the containers must agree on it
+	for _, container := range containers {
+		remediation := container.ConfigMapData.Remediation
+		if remediation == "" {
+			continue
+		}
+		if kuberData.Remediation != "" && kuberData.Remediation != remediation {
+			return nil, fmt.Errorf("%w: containers set different remediations %s and %s", configs.ErrInvalidConfig, kuberData.Remediation, remediation)
+		}
+		kuberData.Remediation = remediation
+	}
+	if kuberData.Remediation == "" {
+		kuberData.Remediation = os.Getenv("REMEDIATION")
+	}
+	switch kuberData.Remediation {
+	case "":
+		kuberData.Remediation = RemediationRestart
+	case RemediationObserve, RemediationAlert, RemediationRestart, RemediationRollback:
+	default:
+		return nil, fmt.Errorf("%w: unknown remediation %s", configs.ErrInvalidConfig, kuberData.Remediation)
+	}
+
 	dataFromK8sAPI := &models.DataFromK8sAPI{
 		KuberData:      kuberData,
 		DeploymentData: deploymentData,
-		ConfigMapData:  configData,
+		Containers:     containers,
 	}
 
 	return dataFromK8sAPI, nil
@@ -58,7 +106,7 @@ func (ks *KuberService) ConnectionToK8sAPI() (*models.KuberData, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		ks.logger.Error(err)
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", configs.ErrInvalidConfig, err)
 	}
 
 	ks.logger.Info("### 💻 Connecting to Kubernetes API, using host: ", config.Host)
@@ -71,80 +119,162 @@ func (ks *KuberService) ConnectionToK8sAPI() (*models.KuberData, error) {
 	namespaceBytes, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
 	if err != nil {
 		ks.logger.Error(err)
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", configs.ErrInvalidConfig, err)
 	}
 	namespace := string(namespaceBytes)
 
-	podName := os.Getenv("POD_NAME")
-
-	targetName := func(podName string) string {
-		elements := strings.Split(podName, "-")
-		newElements := elements[:len(elements)-2]
-		return strings.Join(newElements, "-")
-	}(podName)
-	if targetName == "" {
-		ks.logger.Fatalln("### 💥 Env var DEPLOYMENT_NAME was not set")
+	podName, ok := os.LookupEnv("POD_NAME")
+	if !ok {
+		// The hostname of a pod is its name unless spec.hostname is set
+		podName, err = os.Hostname()
+		if err != nil {
+			ks.logger.Error(err)
+			return nil, err
+		}
 	}
-	targetType := os.Getenv("DEPLOYMENT_TYPE")
 	kuberData := &models.KuberData{
-		Clientset:  clientset,
-		Namespace:  namespace,
-		TargetName: targetName,
-		TargetType: targetType,
+		Clientset: clientset,
+		Namespace: namespace,
+	}
+
+	err = ks.resolveTarget(kuberData, podName)
+	if err != nil {
+		ks.logger.Errorf("can't resolve the workload of the pod %s: %s", podName, err)
+		return nil, err
 	}
+	ks.logger.Infof("### 🎯 Target %v, named %v", kuberData.TargetType, kuberData.TargetName)
 	return kuberData, nil
 }
-func (ks *KuberService) GetDataFromConfigMap(kuberData *models.KuberData, deploymentData *models.DeploymentData) (*models.ConfigMapData, error) {
+
+// GetDataFromConfigMap reads the entries of the ConfigMap for the containers of the pod, keyed by the container name.
+// When no container has an entry of its own, an entry keyed by the value of the MAIN_PROCESS_NAME label of the pod
+// is used for the container named after the label or after the PID_NAME of the entry
+func (ks *KuberService) GetDataFromConfigMap(kuberData *models.KuberData, deploymentData *models.DeploymentData) (map[string]*models.ConfigMapData, error) {
 	cm, err := kuberData.Clientset.CoreV1().ConfigMaps(kuberData.Namespace).Get(context.Background(), deploymentData.ReleaseName+"-"+os.Getenv("CONFIG_MAP_NAME_FOR_HASHER"), metav1.GetOptions{})
 	if err != nil {
 		ks.logger.Error("err while getting data from configMap kuberAPI ", err)
 		return nil, err
 	}
-	var configMapData models.ConfigMapData
-	valuesEnv := make(map[string]string)
-	for key, value := range cm.Data {
-		if key == deploymentData.LabelMainProcessName {
-			envs := strings.Split(strings.TrimSpace(value), "\n")
-			for _, subStr := range envs {
-				valuesEnvs := strings.Split(strings.TrimSpace(subStr), "=")
-				valuesEnv[valuesEnvs[0]] = valuesEnvs[1]
+
+	entries := make(map[string]string)
+	for _, container := range deploymentData.Containers {
+		if value, ok := cm.Data[container.Name]; ok {
+			entries[container.Name] = value
+		}
+	}
+
+	configData := make(map[string]*models.ConfigMapData, len(entries))
+	for containerName, value := range entries {
+		configMapData, err := parseConfigMapEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %s: %s", configs.ErrInvalidConfig, containerName, err)
+		}
+		configData[containerName] = configMapData
+	}
+	if len(configData) == 0 {
+		return ks.labelEntry(cm.Data, deploymentData)
+	}
+	return configData, nil
+}
+
+// labelEntry reads the entry keyed by the value of the MAIN_PROCESS_NAME label. The container is matched by name,
+// the order of the containers is not used as the sidecar may be the first one
+func (ks *KuberService) labelEntry(data map[string]string, deploymentData *models.DeploymentData) (map[string]*models.ConfigMapData, error) {
+	label := deploymentData.LabelMainProcessName
+	value, ok := data[label]
+	if !ok {
+		return map[string]*models.ConfigMapData{}, nil
+	}
+	configMapData, err := parseConfigMapEntry(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: entry %s: %s", configs.ErrInvalidConfig, label, err)
+	}
+	for _, name := range []string{label, configMapData.ProcName} {
+		for _, container := range deploymentData.Containers {
+			if name != "" && container.Name == name {
+				return map[string]*models.ConfigMapData{container.Name: configMapData}, nil
 			}
 		}
 	}
+	return nil, fmt.Errorf("%w: entry %s: no container is named %s or %s, key the entry by the name of the container", configs.ErrInvalidConfig, label, label, configMapData.ProcName)
+}
+
+// parseConfigMapEntry reads the KEY=value lines of an entry of the ConfigMap
+func parseConfigMapEntry(entry string) (*models.ConfigMapData, error) {
+	valuesEnv := make(map[string]string)
+	for _, subStr := range strings.Split(strings.TrimSpace(entry), "\n") {
+		// Patterns may contain "=", so only the first one separates the key
+		key, value, ok := strings.Cut(strings.TrimSpace(subStr), "=")
+		if ok {
+			valuesEnv[key] = value
+		}
+	}
 
+	var configMapData models.ConfigMapData
 	if value, ok := valuesEnv["PID_NAME"]; ok {
 		configMapData.ProcName = value
 	}
-	if value, ok := valuesEnv["MOUNT_PATH"]; ok {
-		configMapData.MountPath = value
+	if value := valuesEnv["PID_CMDLINE"]; value != "" {
+		cmdline, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("PID_CMDLINE: %w", err)
+		}
+		configMapData.ProcCmdline = cmdline
+	}
+	// The processes of the other containers share the process namespace of the pod, they are not searched by default
+	configMapData.ProcInContainer = true
+	if value, ok := valuesEnv["PID_CONTAINER"]; ok {
+		inContainer, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("PID_CONTAINER: %w", err)
+		}
+		configMapData.ProcInContainer = inContainer
+	}
+	if !configMapData.ProcInContainer && configMapData.ProcName == "" && configMapData.ProcCmdline == nil {
+		return nil, errors.New("PID_CONTAINER=false needs PID_NAME or PID_CMDLINE")
+	}
+	if value, ok := valuesEnv["REMEDIATION"]; ok {
+		configMapData.Remediation = value
+	}
+	var err error
+	configMapData.MountPaths, err = parseMountPaths(valuesEnv)
+	if err != nil {
+		return nil, err
 	}
-	return &configMapData, err
+	return &configMapData, nil
 }
 
 func (ks *KuberService) GetDataFromDeployment(kuberData *models.KuberData) (*models.DeploymentData, error) {
-	allDeploymentData, err := kuberData.Clientset.AppsV1().Deployments(kuberData.Namespace).Get(context.Background(), kuberData.TargetName, metav1.GetOptions{})
+	workloadMeta, podTemplate, err := ks.getWorkload(kuberData)
 	if err != nil {
 		ks.logger.Error("err while getting data from kuberAPI ", err)
 		return nil, err
 	}
 
 	deploymentData := &models.DeploymentData{
-		NamePod:        os.Getenv("POD_NAME"),
-		Timestamp:      fmt.Sprintf("%v", allDeploymentData.CreationTimestamp),
+		NamePod:        kuberData.Pod.Name,
+		Timestamp:      fmt.Sprintf("%v", workloadMeta.CreationTimestamp),
 		NameDeployment: kuberData.TargetName,
 	}
 
-	for _, v := range allDeploymentData.Spec.Template.Spec.Containers {
-		deploymentData.Image = v.Image
+	statuses := ks.getContainerStatuses(kuberData)
+	for _, container := range podTemplate.Spec.Containers {
+		status := statuses[container.Name]
+		deploymentData.Containers = append(deploymentData.Containers, models.Container{
+			Name:        container.Name,
+			Image:       container.Image,
+			ImageDigest: image.DigestFromImageID(status.ImageID),
+			ContainerID: status.ContainerID,
+		})
 	}
 
-	for label, value := range allDeploymentData.Spec.Template.Labels {
+	for label, value := range podTemplate.Labels {
 		if label == os.Getenv("MAIN_PROCESS_NAME") {
 			deploymentData.LabelMainProcessName = value
 		}
 	}
 
-	if value, ok := allDeploymentData.Annotations["meta.helm.sh/release-name"]; ok {
+	if value, ok := workloadMeta.Annotations["meta.helm.sh/release-name"]; ok {
 		deploymentData.ReleaseName = value
 	}
 
@@ -152,8 +282,7 @@ func (ks *KuberService) GetDataFromDeployment(kuberData *models.KuberData) (*mod
 }
 
 func (ks *KuberService) RolloutDeployment(kuberData *models.KuberData) error {
-	patchData := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`, time.Now().Format(time.RFC3339))
-	_, err := kuberData.Clientset.AppsV1().Deployments(kuberData.Namespace).Patch(context.Background(), kuberData.TargetName, types.StrategicMergePatchType, []byte(patchData), metav1.PatchOptions{FieldManager: "kubectl-rollout"})
+	err := ks.restartWorkload(kuberData)
 	if err != nil {
 		ks.logger.Printf("### 👎 Warning: Failed to patch %v, restart failed: %v", kuberData.TargetType, err)
 		return err
@@ -162,3 +291,80 @@ func (ks *KuberService) RolloutDeployment(kuberData *models.KuberData) error {
 	}
 	return nil
 }
+
+// RollbackDeployment returns the deployment to the previous revision verified clean
+func (ks *KuberService) RollbackDeployment(kuberData *models.KuberData) error {
+	rev, err := ks.rollbackDeployment(kuberData)
+	if err != nil {
+		ks.logger.Printf("### 👎 Warning: Failed to roll back %v, rollback failed: %v", kuberData.TargetType, err)
+		return err
+	}
+	ks.logger.Printf("### ✅ Target %v, named %v was rolled back to revision %d!", kuberData.TargetType, kuberData.TargetName, rev)
+	return nil
+}
+
+// MarkRevisionVerified records that the revision running the pod passed the integrity check
+func (ks *KuberService) MarkRevisionVerified(kuberData *models.KuberData) error {
+	err := ks.markRevisionVerified(kuberData)
+	if err != nil {
+		ks.logger.Warn("err while marking revision as verified ", err)
+	}
+	return err
+}
+
+// ReportViolation emits IntegrityViolation events for the pod and the target workload
+func (ks *KuberService) ReportViolation(kuberData *models.KuberData, violation *models.Violation) error {
+	err := ks.emitViolationEvents(kuberData, violation)
+	if err != nil {
+		ks.logger.Warn("err while creating events in kuberAPI ", err)
+	}
+	return err
+}
+
+// ReportProcessRestart emits a MonitoredProcessRestarted event for the pod
+func (ks *KuberService) ReportProcessRestart(kuberData *models.KuberData, restart *models.ProcessRestart) error {
+	err := ks.emitProcessRestartedEvent(kuberData, restart)
+	if err != nil {
+		ks.logger.Warn("err while creating events in kuberAPI ", err)
+	}
+	return err
+}
+
+// GetContainerID returns the ID of the running container as reported in the pod status, it changes when the container restarts
+func (ks *KuberService) GetContainerID(kuberData *models.KuberData, containerName string) (string, error) {
+	pod, err := kuberData.Clientset.CoreV1().Pods(kuberData.Namespace).Get(context.Background(), kuberData.Pod.Name, metav1.GetOptions{})
+	if err != nil {
+		ks.logger.Error("err while getting pod from kuberAPI ", err)
+		return "", err
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName {
+			return status.ContainerID, nil
+		}
+	}
+	return "", nil
+}
+
+// AnnotateScanResult records the result of the last check in the annotations of the pod
+func (ks *KuberService) AnnotateScanResult(kuberData *models.KuberData, changedFiles int) error {
+	err := ks.annotateScanResult(kuberData, changedFiles)
+	if err != nil {
+		ks.logger.Warn("err while annotating pod in kuberAPI ", err)
+	}
+	return err
+}
+
+// getContainerStatuses returns the statuses of the containers of the pod by the container name,
+// they hold the digests of the images the containers are running and the container IDs
+func (ks *KuberService) getContainerStatuses(kuberData *models.KuberData) map[string]corev1.ContainerStatus {
+	statuses := make(map[string]corev1.ContainerStatus)
+	pod, err := kuberData.Clientset.CoreV1().Pods(kuberData.Namespace).Get(context.Background(), kuberData.Pod.Name, metav1.GetOptions{})
+	if err != nil {
+		ks.logger.Warn("err while getting pod from kuberAPI ", err)
+		return statuses
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses[status.Name] = status
+	}
+	return statuses
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetDataFromDeploymentAndConfigMap(t *testing.T) {
+	t.Setenv("CONFIG_MAP_NAME_FOR_HASHER", "integrity-sum-config")
+	t.Setenv("MAIN_PROCESS_NAME", "main-process-name")
+
+	deploymentMeta := objectMeta("app", "deploy-uid", nil)
+	deploymentMeta.Annotations = map[string]string{"meta.helm.sh/release-name": "release"}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: deploymentMeta,
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"main-process-name": "web"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "nginx", Image: "nginx:1.23"},
+				{Name: "php", Image: "php:8.1-fpm"},
+				{Name: "integrity-sum", Image: "integrity-sum:latest"},
+			}},
+		}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: objectMeta("app-0", "pod-uid", nil),
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "nginx", ImageID: "docker-pullable://nginx@sha256:aaa", ContainerID: "containerd://c1"},
+			{Name: "php", ImageID: "docker-pullable://php@sha256:bbb"},
+		}},
+	}
+	configMap := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: objectMeta("release-integrity-sum-config", "cm-uid", nil), Data: data}
+	}
+
+	testTable := []struct {
+		name          string
+		configMap     *corev1.ConfigMap
+		expected      map[string]string
+		expectedError bool
+	}{
+		{
+			name: "entries keyed by container",
+			configMap: configMap(map[string]string{
+				"nginx": "PID_NAME=nginx\nMOUNT_PATH=etc/nginx",
+				"php":   "PID_NAME=php-fpm\nMOUNT_PATH=usr/local/etc,var/www",
+			}),
+			expected: map[string]string{"nginx": "nginx", "php": "php-fpm"},
+		},
+		{
+			name:      "entry keyed by the process label",
+			configMap: configMap(map[string]string{"web": "PID_NAME=nginx\nMOUNT_PATH=etc/nginx"}),
+			expected:  map[string]string{"nginx": "nginx"},
+		},
+		{
+			name:      "entry keyed by the process label matched by container name",
+			configMap: configMap(map[string]string{"web": "PID_NAME=php\nMOUNT_PATH=var/www"}),
+			expected:  map[string]string{"php": "php"},
+		},
+		{
+			name:          "entry keyed by the process label without a matching container",
+			configMap:     configMap(map[string]string{"web": "PID_NAME=httpd\nMOUNT_PATH=etc/httpd"}),
+			expectedError: true,
+		},
+		{
+			name:          "invalid entry",
+			configMap:     configMap(map[string]string{"php": "PID_NAME=php-fpm"}),
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			kuberData := &models.KuberData{
+				Clientset:  fake.NewSimpleClientset(deployment, pod, testCase.configMap),
+				Namespace:  "default",
+				TargetName: "app",
+				TargetType: KindDeployment,
+				Pod:        models.WorkloadIdentity{Name: "app-0"},
+			}
+			ks := NewKuberService(logrus.New())
+
+			deploymentData, err := ks.GetDataFromDeployment(kuberData)
+			require.NoError(t, err)
+			assert.Equal(t, []models.Container{
+				{Name: "nginx", Image: "nginx:1.23", ImageDigest: "sha256:aaa", ContainerID: "containerd://c1"},
+				{Name: "php", Image: "php:8.1-fpm", ImageDigest: "sha256:bbb"},
+				{Name: "integrity-sum", Image: "integrity-sum:latest"},
+			}, deploymentData.Containers)
+
+			configData, err := ks.GetDataFromConfigMap(kuberData, deploymentData)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			procNames := make(map[string]string, len(configData))
+			for containerName, data := range configData {
+				procNames[containerName] = data.ProcName
+			}
+			assert.Equal(t, testCase.expected, procNames)
+		})
+	}
+}
+
+func TestParseConfigMapEntryProcess(t *testing.T) {
+	testTable := []struct {
+		name              string
+		entry             string
+		expectedContainer bool
+		expectedError     bool
+	}{
+		{name: "by container", entry: "MOUNT_PATH=etc/nginx", expectedContainer: true},
+		{name: "name within the container by default", entry: "PID_NAME=nginx\nMOUNT_PATH=etc/nginx", expectedContainer: true},
+		{name: "name in the whole pod", entry: "PID_NAME=nginx\nPID_CONTAINER=false\nMOUNT_PATH=etc/nginx"},
+		{name: "nothing to search the whole pod for", entry: "PID_CONTAINER=false\nMOUNT_PATH=etc/nginx", expectedError: true},
+		{name: "invalid container flag", entry: "PID_CONTAINER=maybe\nMOUNT_PATH=etc/nginx", expectedError: true},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			configMapData, err := parseConfigMapEntry(testCase.entry)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedContainer, configMapData.ProcInContainer)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/pkg/pathfilter"
+)
+
+// mountPathKeySeparator separates a key of the ConfigMap entry from the mount path it applies to, as in EXCLUDE@etc/ssl
+const mountPathKeySeparator = "@"
+
+// parseMountPaths reads the comma-separated MOUNT_PATH list of the ConfigMap entry.
+// INCLUDE and EXCLUDE apply to every path, INCLUDE@<path> and EXCLUDE@<path> add patterns for one path
+func parseMountPaths(values map[string]string) ([]models.MountPath, error) {
+	var mountPaths []string
+	for _, value := range strings.Split(values["MOUNT_PATH"], ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		mountPath := cleanMountPath(value)
+		for _, existing := range mountPaths {
+			if isUnderMountPath(existing, mountPath) || isUnderMountPath(mountPath, existing) {
+				return nil, fmt.Errorf("mount paths %s and %s overlap", existing, mountPath)
+			}
+		}
+		mountPaths = append(mountPaths, mountPath)
+	}
+	if len(mountPaths) == 0 {
+		return nil, errors.New("MOUNT_PATH is not set")
+	}
+
+	include := make(map[string][]string)
+	exclude := make(map[string][]string)
+	for key, value := range values {
+		name, mountPath, ok := strings.Cut(key, mountPathKeySeparator)
+		if !ok {
+			continue
+		}
+		mountPath = cleanMountPath(mountPath)
+		switch name {
+		case "INCLUDE":
+			include[mountPath] = append(include[mountPath], pathfilter.Split(value)...)
+		case "EXCLUDE":
+			exclude[mountPath] = append(exclude[mountPath], pathfilter.Split(value)...)
+		default:
+			continue
+		}
+		if !containsString(mountPaths, mountPath) {
+			return nil, fmt.Errorf("%s refers to %s which is not in MOUNT_PATH", key, mountPath)
+		}
+	}
+
+	result := make([]models.MountPath, 0, len(mountPaths))
+	for _, mountPath := range mountPaths {
+		filter, err := pathfilter.New(
+			append(pathfilter.Split(values["INCLUDE"]), include[mountPath]...),
+			append(pathfilter.Split(values["EXCLUDE"]), exclude[mountPath]...),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("patterns of %s: %w", mountPath, err)
+		}
+		result = append(result, models.MountPath{Path: mountPath, Filter: filter})
+	}
+	return result, nil
+}
+
+// cleanMountPath returns the path relative to the root of the process without leading and trailing slashes
+func cleanMountPath(mountPath string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(mountPath)), "/")
+}
+
+// mountDir returns the directory of the mount path seen through the root of the process
+func mountDir(procRoot string, mountPath models.MountPath) string {
+	return procRoot + "/" + mountPath.Path
+}
+
+// processPath returns the path of a file under the root of the process as the process sees it,
+// so the paths saved in the database don't depend on the PID
+func processPath(procRoot, name string) string {
+	return path.Join("/", pathfilter.Rel(procRoot, name))
+}
+
+// isUnderMountPath reports whether name is the mount path itself or inside it, an empty mount path is the whole root
+func isUnderMountPath(name, mountPath string) bool {
+	return mountPath == "" || name == mountPath || strings.HasPrefix(name, mountPath+"/")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMountPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    []string
+		match   map[string]map[string]bool
+		wantErr bool
+	}{
+		{
+			name:   "single path",
+			values: map[string]string{"MOUNT_PATH": "etc/nginx"},
+			want:   []string{"etc/nginx"},
+		},
+		{
+			name: "paths with their own patterns",
+			values: map[string]string{
+				"MOUNT_PATH":        "/etc/nginx/, usr/sbin",
+				"EXCLUDE":           "*.pid",
+				"EXCLUDE@etc/nginx": "cache/",
+				"INCLUDE@usr/sbin":  "nginx",
+			},
+			want: []string{"etc/nginx", "usr/sbin"},
+			match: map[string]map[string]bool{
+				"etc/nginx": {"nginx.conf": true, "cache/a": false, "nginx.pid": false},
+				"usr/sbin":  {"nginx": true, "nginx-debug": false, "nginx.pid": false},
+			},
+		},
+		{
+			name:    "missing mount path",
+			values:  map[string]string{"PID_NAME": "nginx"},
+			wantErr: true,
+		},
+		{
+			name:    "overlapping paths",
+			values:  map[string]string{"MOUNT_PATH": "etc,etc/nginx"},
+			wantErr: true,
+		},
+		{
+			name:    "patterns of an unknown path",
+			values:  map[string]string{"MOUNT_PATH": "etc/nginx", "EXCLUDE@etc/ssl": "*.key"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mountPaths, err := parseMountPaths(tt.values)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var got []string
+			for _, mountPath := range mountPaths {
+				got = append(got, mountPath.Path)
+				for rel, want := range tt.match[mountPath.Path] {
+					assert.Equal(t, want, mountPath.Filter.Match(rel), mountPath.Path+"/"+rel)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFlags don't follow a symlink replacing the file and don't wait for the writer of a FIFO replacing it
+const openFlags = unix.O_RDONLY | unix.O_NOFOLLOW | unix.O_NONBLOCK | unix.O_CLOEXEC
+
+// openRegular opens the file under root for reading only if it is a regular file. The file may be replaced after it was found,
+// so the path is resolved again under root as the process sees it and a symlink swapped in for a directory never leads out of it,
+// a symlink swapped in for the file is not followed and a FIFO or a device is not waited for or read
+func openRegular(root, name string) (*os.File, os.FileInfo, error) {
+	if root == "" {
+		root = "/"
+	}
+	// A relative path given on the command line is resolved from the working directory
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, nil, fmt.Errorf("%s is not under %s", name, root)
+	}
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	fd, err := unix.Openat2(rootFd, rel, &unix.OpenHow{
+		Flags:   openFlags,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	// Kernels before 5.6 and seccomp profiles which don't know openat2
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) {
+		fd, err = openComponents(rootFd, rel)
+	}
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return regularFile(os.NewFile(uintptr(fd), name), name)
+}
+
+// openComponents opens the path under the directory one component at a time without following any symlink,
+// so a path containing a symlink fails to open instead of being resolved outside of the directory
+func openComponents(dirFd int, rel string) (int, error) {
+	parts := strings.Split(rel, "/")
+	dir, err := unix.Dup(dirFd)
+	if err != nil {
+		return -1, err
+	}
+	for _, part := range parts[:len(parts)-1] {
+		next, err := unix.Openat(dir, part, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(dir)
+		if err != nil {
+			return -1, err
+		}
+		dir = next
+	}
+	defer unix.Close(dir)
+	return unix.Openat(dir, parts[len(parts)-1], openFlags, 0)
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/integrity-sum/pkg/api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenRegular(t *testing.T) {
+	// root is the filesystem of the process, outside is the filesystem of the sidecar
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{root, outside} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "etc", "nginx"), 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "etc", "nginx", "nginx.conf"), []byte(dir), 0o600))
+	}
+	name := filepath.Join(root, "etc", "nginx", "nginx.conf")
+
+	file, info, err := openRegular(root, name)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, root, string(content))
+	assert.True(t, info.Mode().IsRegular())
+
+	_, _, err = openRegular(root, filepath.Join(outside, "etc", "nginx", "nginx.conf"))
+	assert.Error(t, err)
+
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY, 0)
+	require.NoError(t, err)
+	fd, err := openComponents(rootFd, "etc/nginx/nginx.conf")
+	require.NoError(t, err)
+	require.NoError(t, unix.Close(fd))
+	require.NoError(t, unix.Close(rootFd))
+
+	// A directory of the path is replaced by a symlink after the file was found
+	for _, target := range []string{filepath.Join(outside, "etc"), "../../../../../../../../" + outside + "/etc"} {
+		require.NoError(t, os.Rename(filepath.Join(root, "etc"), filepath.Join(root, "etc.orig")))
+		require.NoError(t, os.Symlink(target, filepath.Join(root, "etc")))
+		file, _, err := openRegular(root, name)
+		if err == nil {
+			content, err = io.ReadAll(file)
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+			assert.NotEqual(t, outside, string(content), target)
+		}
+		// Without openat2 a symlink in the path is not followed at all
+		rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY, 0)
+		require.NoError(t, err)
+		_, err = openComponents(rootFd, "etc/nginx/nginx.conf")
+		assert.Error(t, err)
+		require.NoError(t, unix.Close(rootFd))
+		require.NoError(t, os.Remove(filepath.Join(root, "etc")))
+		require.NoError(t, os.Rename(filepath.Join(root, "etc.orig"), filepath.Join(root, "etc")))
+	}
+}
+
+func TestCreateHashSwappedForFIFO(t *testing.T) {
+	root := t.TempDir()
+	name := filepath.Join(root, "nginx.conf")
+	require.NoError(t, os.WriteFile(name, []byte("config"), 0o600))
+	fifo := filepath.Join(root, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o600))
+	hs := NewHashService(nil, "SHA256", logrus.New())
+
+	// The file the content is read from was replaced by a FIFO without a writer
+	errs := make(chan error, 1)
+	go func() {
+		_, err := hs.CreateHash(api.Job{Path: name, Content: fifo, Root: root})
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, errNotRegular)
+	case <-time.After(5 * time.Second):
+		t.Fatal("hashing blocked on the FIFO")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package services
+
+import (
+	"os"
+	"syscall"
+)
+
+// openRegular opens the file for reading only if it is a regular file. The file may be replaced after it was found,
+// so a symlink is not followed and a FIFO or a device is not waited for or read. The root is only used on Linux
+func openRegular(_, name string) (*os.File, os.FileInfo, error) {
+	file, err := os.OpenFile(name, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return regularFile(file, name)
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/integrity-sum/internal/configs"
+	"github.com/integrity-sum/internal/core/models"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Kinds of the workloads which pods can be protected by the sidecar
+const (
+	KindDeployment  = "deployment"
+	KindStatefulSet = "statefulset"
+	KindDaemonSet   = "daemonset"
+	KindReplicaSet  = "replicaset"
+)
+
+const (
+	annotationRevision   = "deployment.kubernetes.io/revision"
+	annotationVerifiedAt = "integrity-sum/verified-at"
+)
+
+// ErrRollbackUnavailable is returned when the workload has no revision to roll back to
+var ErrRollbackUnavailable = errors.New("rollback unavailable")
+
+// maxOwnerDepth limits the walk over owner references
+const maxOwnerDepth = 8
+
+// resolveTarget walks the owner references from the pod up to its top-level controller,
+// for example Pod -> ReplicaSet -> Deployment, and stores the identities in kuberData.
+// The target is the topmost controller of a supported kind
+func (ks *KuberService) resolveTarget(kuberData *models.KuberData, podName string) error {
+	ctx := context.Background()
+	pod, err := kuberData.Clientset.CoreV1().Pods(kuberData.Namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	kuberData.Pod = models.WorkloadIdentity{APIVersion: "v1", Kind: "Pod", Name: pod.Name, UID: string(pod.UID)}
+	kuberData.Owners = nil
+
+	var object metav1.Object = pod
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		owner := metav1.GetControllerOf(object)
+		if owner == nil {
+			break
+		}
+		kuberData.Owners = append(kuberData.Owners, models.WorkloadIdentity{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        string(owner.UID),
+		})
+
+		object, err = ks.getOwner(ctx, kuberData, owner)
+		if err != nil {
+			return err
+		}
+		if object == nil {
+			break
+		}
+	}
+
+	for i := len(kuberData.Owners) - 1; i >= 0; i-- {
+		if isSupportedKind(kuberData.Owners[i].Kind) {
+			kuberData.Target = kuberData.Owners[i]
+			kuberData.TargetName = kuberData.Target.Name
+			kuberData.TargetType = strings.ToLower(kuberData.Target.Kind)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: pod %s is not controlled by a Deployment, StatefulSet, DaemonSet or ReplicaSet", configs.ErrInvalidConfig, podName)
+}
+
+// getOwner fetches the controller from the owner reference, it returns nil for kinds that can't be fetched
+func (ks *KuberService) getOwner(ctx context.Context, kuberData *models.KuberData, owner *metav1.OwnerReference) (metav1.Object, error) {
+	apps := kuberData.Clientset.AppsV1()
+	var object metav1.Object
+	var err error
+	switch owner.Kind {
+	case "ReplicaSet":
+		object, err = apps.ReplicaSets(kuberData.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	case "Deployment":
+		object, err = apps.Deployments(kuberData.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	case "StatefulSet":
+		object, err = apps.StatefulSets(kuberData.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	case "DaemonSet":
+		object, err = apps.DaemonSets(kuberData.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	case "Job":
+		object, err = kuberData.Clientset.BatchV1().Jobs(kuberData.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if object.GetUID() != owner.UID {
+		return nil, fmt.Errorf("%s %s was replaced, its uid %s differs from the owner reference", owner.Kind, owner.Name, object.GetUID())
+	}
+	return object, nil
+}
+
+func isSupportedKind(kind string) bool {
+	switch strings.ToLower(kind) {
+	case KindDeployment, KindStatefulSet, KindDaemonSet, KindReplicaSet:
+		return true
+	}
+	return false
+}
+
+// getWorkload returns the metadata and the pod template of the target workload
+func (ks *KuberService) getWorkload(kuberData *models.KuberData) (*metav1.ObjectMeta, *corev1.PodTemplateSpec, error) {
+	ctx := context.Background()
+	apps := kuberData.Clientset.AppsV1()
+	switch kuberData.TargetType {
+	case KindDeployment:
+		deployment, err := apps.Deployments(kuberData.Namespace).Get(ctx, kuberData.TargetName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &deployment.ObjectMeta, &deployment.Spec.Template, nil
+	case KindStatefulSet:
+		statefulSet, err := apps.StatefulSets(kuberData.Namespace).Get(ctx, kuberData.TargetName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &statefulSet.ObjectMeta, &statefulSet.Spec.Template, nil
+	case KindDaemonSet:
+		daemonSet, err := apps.DaemonSets(kuberData.Namespace).Get(ctx, kuberData.TargetName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &daemonSet.ObjectMeta, &daemonSet.Spec.Template, nil
+	case KindReplicaSet:
+		replicaSet, err := apps.ReplicaSets(kuberData.Namespace).Get(ctx, kuberData.TargetName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &replicaSet.ObjectMeta, &replicaSet.Spec.Template, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported workload type %s", kuberData.TargetType)
+	}
+}
+
+// restartWorkload restarts the pods of the target workload. Deployments, StatefulSets and DaemonSets
+// are restarted with a rollout like `kubectl rollout restart`, a bare ReplicaSet does not roll out
+// template changes, so its pods are deleted and recreated by the ReplicaSet
+func (ks *KuberService) restartWorkload(kuberData *models.KuberData) error {
+	ctx := context.Background()
+	apps := kuberData.Clientset.AppsV1()
+	patchData := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`, time.Now().Format(time.RFC3339)))
+	patchOptions := metav1.PatchOptions{FieldManager: "kubectl-rollout"}
+
+	var err error
+	switch kuberData.TargetType {
+	case KindDeployment:
+		_, err = apps.Deployments(kuberData.Namespace).Patch(ctx, kuberData.TargetName, types.StrategicMergePatchType, patchData, patchOptions)
+	case KindStatefulSet:
+		_, err = apps.StatefulSets(kuberData.Namespace).Patch(ctx, kuberData.TargetName, types.StrategicMergePatchType, patchData, patchOptions)
+	case KindDaemonSet:
+		_, err = apps.DaemonSets(kuberData.Namespace).Patch(ctx, kuberData.TargetName, types.StrategicMergePatchType, patchData, patchOptions)
+	case KindReplicaSet:
+		err = ks.deleteReplicaSetPods(kuberData)
+	default:
+		err = fmt.Errorf("unsupported workload type %s", kuberData.TargetType)
+	}
+	return err
+}
+
+func (ks *KuberService) deleteReplicaSetPods(kuberData *models.KuberData) error {
+	ctx := context.Background()
+	replicaSet, err := kuberData.Clientset.AppsV1().ReplicaSets(kuberData.Namespace).Get(ctx, kuberData.TargetName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(replicaSet.Spec.Selector)
+	if err != nil {
+		return err
+	}
+
+	pods := kuberData.Clientset.CoreV1().Pods(kuberData.Namespace)
+	podList, err := pods.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if owner := metav1.GetControllerOf(pod); owner == nil || owner.UID != replicaSet.UID {
+			continue
+		}
+		if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markRevisionVerified annotates the ReplicaSet running the pod after its files were checked without differences,
+// rollbacks only return to revisions marked this way
+func (ks *KuberService) markRevisionVerified(kuberData *models.KuberData) error {
+	if len(kuberData.Owners) == 0 || kuberData.Owners[0].Kind != "ReplicaSet" {
+		return nil
+	}
+	patchData := []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s"}}}`, annotationVerifiedAt, time.Now().Format(time.RFC3339)))
+	_, err := kuberData.Clientset.AppsV1().ReplicaSets(kuberData.Namespace).Patch(context.Background(), kuberData.Owners[0].Name,
+		types.MergePatchType, patchData, metav1.PatchOptions{})
+	return err
+}
+
+// rollbackDeployment replaces the pod template of the deployment with the template of the newest earlier
+// ReplicaSet revision marked as verified, the same way `kubectl rollout undo` does
+func (ks *KuberService) rollbackDeployment(kuberData *models.KuberData) (int64, error) {
+	if kuberData.TargetType != KindDeployment {
+		return 0, fmt.Errorf("%w: %s can't be rolled back, only deployments can", ErrRollbackUnavailable, kuberData.TargetType)
+	}
+	ctx := context.Background()
+	apps := kuberData.Clientset.AppsV1()
+	deployment, err := apps.Deployments(kuberData.Namespace).Get(ctx, kuberData.TargetName, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return 0, err
+	}
+	replicaSets, err := apps.ReplicaSets(kuberData.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return 0, err
+	}
+
+	currentRevision := revision(&deployment.ObjectMeta)
+	var previous *appsv1.ReplicaSet
+	var previousRevision int64
+	for i := range replicaSets.Items {
+		replicaSet := &replicaSets.Items[i]
+		if owner := metav1.GetControllerOf(replicaSet); owner == nil || owner.UID != deployment.UID {
+			continue
+		}
+		// The ReplicaSet of the pod where the violation was found is never a rollback target
+		if len(kuberData.Owners) > 0 && string(replicaSet.UID) == kuberData.Owners[0].UID {
+			continue
+		}
+		if _, ok := replicaSet.Annotations[annotationVerifiedAt]; !ok {
+			continue
+		}
+		rev := revision(&replicaSet.ObjectMeta)
+		if rev >= currentRevision || rev <= previousRevision {
+			continue
+		}
+		previous, previousRevision = replicaSet, rev
+	}
+	if previous == nil {
+		return 0, fmt.Errorf("%w: deployment %s has no verified revision before %d", ErrRollbackUnavailable, deployment.Name, currentRevision)
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	patchData, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, err = apps.Deployments(kuberData.Namespace).Patch(ctx, deployment.Name, types.JSONPatchType, patchData, metav1.PatchOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return previousRevision, nil
+}
+
+// revision returns the revision the deployment controller stored in the annotations, 0 if it is missing
+func revision(meta *metav1.ObjectMeta) int64 {
+	rev, err := strconv.ParseInt(meta.Annotations[annotationRevision], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return rev
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func objectMeta(name, uid string, owner *metav1.OwnerReference) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid)}
+	if owner != nil {
+		controller := true
+		owner.Controller = &controller
+		meta.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return meta
+}
+
+func TestResolveTarget(t *testing.T) {
+	testTable := []struct {
+		name          string
+		podName       string
+		objects       []runtime.Object
+		expected      models.WorkloadIdentity
+		expectedOwner int
+		expectedError bool
+	}{
+		{
+			name:    "deployment",
+			podName: "app-6d4cf56db6-x2x7p",
+			objects: []runtime.Object{
+				&corev1.Pod{ObjectMeta: objectMeta("app-6d4cf56db6-x2x7p", "pod-uid", &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-6d4cf56db6", UID: "rs-uid"})},
+				&appsv1.ReplicaSet{ObjectMeta: objectMeta("app-6d4cf56db6", "rs-uid", &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "deploy-uid"})},
+				&appsv1.Deployment{ObjectMeta: objectMeta("app", "deploy-uid", nil)},
+			},
+			expected:      models.WorkloadIdentity{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "deploy-uid"},
+			expectedOwner: 2,
+		},
+		{
+			name:    "statefulset with a short pod name",
+			podName: "db-0",
+			objects: []runtime.Object{
+				&corev1.Pod{ObjectMeta: objectMeta("db-0", "pod-uid", &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", UID: "sts-uid"})},
+				&appsv1.StatefulSet{ObjectMeta: objectMeta("db", "sts-uid", nil)},
+			},
+			expected:      models.WorkloadIdentity{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", UID: "sts-uid"},
+			expectedOwner: 1,
+		},
+		{
+			name:    "replicaset owned by an unknown controller",
+			podName: "web-abcde",
+			objects: []runtime.Object{
+				&corev1.Pod{ObjectMeta: objectMeta("web-abcde", "pod-uid", &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", UID: "rs-uid"})},
+				&appsv1.ReplicaSet{ObjectMeta: objectMeta("web", "rs-uid", &metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Rollout", Name: "web", UID: "rollout-uid"})},
+			},
+			expected:      models.WorkloadIdentity{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", UID: "rs-uid"},
+			expectedOwner: 2,
+		},
+		{
+			name:    "bare pod",
+			podName: "pod",
+			objects: []runtime.Object{
+				&corev1.Pod{ObjectMeta: objectMeta("pod", "pod-uid", nil)},
+			},
+			expectedError: true,
+		},
+		{
+			name:    "owner was replaced",
+			podName: "app-0",
+			objects: []runtime.Object{
+				&corev1.Pod{ObjectMeta: objectMeta("app-0", "pod-uid", &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "app", UID: "old-uid"})},
+				&appsv1.StatefulSet{ObjectMeta: objectMeta("app", "new-uid", nil)},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ks := NewKuberService(logrus.New())
+			kuberData := &models.KuberData{Clientset: fake.NewSimpleClientset(testCase.objects...), Namespace: "default"}
+
+			err := ks.resolveTarget(kuberData, testCase.podName)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.podName, kuberData.Pod.Name)
+			assert.Equal(t, testCase.expected, kuberData.Target)
+			assert.Len(t, kuberData.Owners, testCase.expectedOwner)
+			assert.Equal(t, testCase.expected.Name, kuberData.TargetName)
+		})
+	}
+}
+
+func replicaSet(name, uid, rev string, verified bool, image string) *appsv1.ReplicaSet {
+	meta := objectMeta(name, uid, &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "deploy-uid"})
+	meta.Labels = map[string]string{"app": "app"}
+	meta.Annotations = map[string]string{annotationRevision: rev}
+	if verified {
+		meta.Annotations[annotationVerifiedAt] = "2022-06-01T00:00:00Z"
+	}
+	return &appsv1.ReplicaSet{
+		ObjectMeta: meta,
+		Spec: appsv1.ReplicaSetSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "app", appsv1.DefaultDeploymentUniqueLabelKey: name}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+			},
+		},
+	}
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	testTable := []struct {
+		name             string
+		replicaSets      []runtime.Object
+		expectedRevision int64
+		expectedImage    string
+		expectedError    error
+	}{
+		{
+			name: "newest verified revision",
+			replicaSets: []runtime.Object{
+				replicaSet("app-1", "rs-1", "1", true, "app:1"),
+				replicaSet("app-2", "rs-2", "2", true, "app:2"),
+				replicaSet("app-3", "rs-3", "3", false, "app:3"),
+				replicaSet("app-4", "rs-4", "4", true, "app:4"),
+			},
+			expectedRevision: 2,
+			expectedImage:    "app:2",
+		},
+		{
+			name: "no verified revision",
+			replicaSets: []runtime.Object{
+				replicaSet("app-3", "rs-3", "3", false, "app:3"),
+				replicaSet("app-4", "rs-4", "4", true, "app:4"),
+			},
+			expectedError: ErrRollbackUnavailable,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: objectMeta("app", "deploy-uid", nil),
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:4"}}}},
+				},
+			}
+			deployment.Annotations = map[string]string{annotationRevision: "4"}
+			clientset := fake.NewSimpleClientset(append(testCase.replicaSets, deployment)...)
+			kuberData := &models.KuberData{
+				Clientset:  clientset,
+				Namespace:  "default",
+				TargetName: "app",
+				TargetType: KindDeployment,
+				Owners:     []models.WorkloadIdentity{{Kind: "ReplicaSet", Name: "app-4", UID: "rs-4"}},
+			}
+
+			rev, err := NewKuberService(logrus.New()).rollbackDeployment(kuberData)
+			if testCase.expectedError != nil {
+				assert.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedRevision, rev)
+
+			updated, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedImage, updated.Spec.Template.Spec.Containers[0].Image)
+			assert.NotContains(t, updated.Spec.Template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+		})
+	}
+}
+
+func TestWorkloadKinds(t *testing.T) {
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "app"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.23"}}},
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}
+	restartedAt := func(t *testing.T, annotations map[string]string) {
+		assert.NotEmpty(t, annotations["kubectl.kubernetes.io/restartedAt"])
+	}
+
+	testTable := []struct {
+		name      string
+		kind      string
+		objects   []runtime.Object
+		restarted func(t *testing.T, clientset *fake.Clientset)
+	}{
+		{
+			name:    "deployment",
+			kind:    KindDeployment,
+			objects: []runtime.Object{&appsv1.Deployment{ObjectMeta: objectMeta("app", "uid", nil), Spec: appsv1.DeploymentSpec{Template: template}}},
+			restarted: func(t *testing.T, clientset *fake.Clientset) {
+				deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+				require.NoError(t, err)
+				restartedAt(t, deployment.Spec.Template.Annotations)
+			},
+		},
+		{
+			name:    "statefulset",
+			kind:    KindStatefulSet,
+			objects: []runtime.Object{&appsv1.StatefulSet{ObjectMeta: objectMeta("app", "uid", nil), Spec: appsv1.StatefulSetSpec{Template: template}}},
+			restarted: func(t *testing.T, clientset *fake.Clientset) {
+				statefulSet, err := clientset.AppsV1().StatefulSets("default").Get(context.Background(), "app", metav1.GetOptions{})
+				require.NoError(t, err)
+				restartedAt(t, statefulSet.Spec.Template.Annotations)
+			},
+		},
+		{
+			name:    "daemonset",
+			kind:    KindDaemonSet,
+			objects: []runtime.Object{&appsv1.DaemonSet{ObjectMeta: objectMeta("app", "uid", nil), Spec: appsv1.DaemonSetSpec{Template: template}}},
+			restarted: func(t *testing.T, clientset *fake.Clientset) {
+				daemonSet, err := clientset.AppsV1().DaemonSets("default").Get(context.Background(), "app", metav1.GetOptions{})
+				require.NoError(t, err)
+				restartedAt(t, daemonSet.Spec.Template.Annotations)
+			},
+		},
+		{
+			name: "bare replicaset",
+			kind: KindReplicaSet,
+			objects: []runtime.Object{
+				&appsv1.ReplicaSet{ObjectMeta: objectMeta("app", "rs-uid", nil), Spec: appsv1.ReplicaSetSpec{Selector: selector, Template: template}},
+				&corev1.Pod{ObjectMeta: func() metav1.ObjectMeta {
+					meta := objectMeta("app-x2x7p", "pod-uid", &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app", UID: "rs-uid"})
+					meta.Labels = map[string]string{"app": "app"}
+					return meta
+				}()},
+			},
+			restarted: func(t *testing.T, clientset *fake.Clientset) {
+				pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+				require.NoError(t, err)
+				assert.Empty(t, pods.Items)
+			},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ks := NewKuberService(logrus.New())
+			clientset := fake.NewSimpleClientset(testCase.objects...)
+			kuberData := &models.KuberData{Clientset: clientset, Namespace: "default", TargetType: testCase.kind, TargetName: "app"}
+
+			meta, podTemplate, err := ks.getWorkload(kuberData)
+			require.NoError(t, err)
+			assert.Equal(t, "app", meta.Name)
+			assert.Equal(t, "nginx:1.23", podTemplate.Spec.Containers[0].Image)
+
+			require.NoError(t, ks.restartWorkload(kuberData))
+			testCase.restarted(t, clientset)
+		})
+	}
+
+	ks := NewKuberService(logrus.New())
+	kuberData := &models.KuberData{Clientset: fake.NewSimpleClientset(), Namespace: "default", TargetType: "cronjob", TargetName: "app"}
+	_, _, err := ks.getWorkload(kuberData)
+	assert.Error(t, err)
+	assert.Error(t, ks.restartWorkload(kuberData))
+}
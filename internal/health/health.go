@@ -0,0 +1,137 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status tracks the progress of the sidecar for the liveness and readiness probes
+type Status struct {
+	mu            sync.Mutex
+	pidFound      bool
+	baselineSaved bool
+	checked       bool
+	degraded      error
+	degradedSince time.Time
+	lastProgress  time.Time
+	stallAfter    time.Duration
+	maxDegraded   time.Duration
+	now           func() time.Time
+}
+
+// New creates a Status which reports the sidecar as not alive when no progress was made for stallAfter.
+// A sidecar retrying a failed operation stays alive for maxDegraded at most
+func New(stallAfter, maxDegraded time.Duration) *Status {
+	return &Status{
+		lastProgress: time.Now(),
+		stallAfter:   stallAfter,
+		maxDegraded:  maxDegraded,
+		now:          time.Now,
+	}
+}
+
+// SetPIDFound records that the monitored process was found
+func (s *Status) SetPIDFound() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pidFound = true
+	s.lastProgress = s.now()
+}
+
+// SetBaselineSaved records that the baseline exists in the database
+func (s *Status) SetBaselineSaved() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baselineSaved = true
+	s.lastProgress = s.now()
+}
+
+// CheckCompleted records that a check finished
+func (s *Status) CheckCompleted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = true
+	s.lastProgress = s.now()
+}
+
+// SetDegraded records the error of a failed operation which is being retried, nil clears it when the operation succeeded.
+// Retrying is not progress, but a sidecar waiting for the database is not restarted until it is degraded for maxDegraded
+func (s *Status) SetDegraded(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && s.degraded == nil:
+		s.degradedSince = s.now()
+	case err == nil && s.degraded != nil:
+		s.lastProgress = s.now()
+	}
+	s.degraded = err
+}
+
+// Degraded returns the error of the operation being retried, nil if everything works
+func (s *Status) Degraded() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.degraded
+}
+
+// Ready reports whether the process was found, the baseline exists and a check completed
+func (s *Status) Ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.pidFound:
+		return errors.New("monitored process not found yet")
+	case !s.baselineSaved:
+		return errors.New("baseline not saved yet")
+	case !s.checked:
+		return errors.New("no check completed yet")
+	}
+	return nil
+}
+
+// Alive reports whether the sidecar made progress recently or is waiting for a failed operation not for too long
+func (s *Status) Alive() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.degraded != nil {
+		if degraded := s.now().Sub(s.degradedSince); degraded > s.maxDegraded {
+			return fmt.Errorf("degraded for %s: %s", degraded.Round(time.Second), s.degraded)
+		}
+		return nil
+	}
+	if stalled := s.now().Sub(s.lastProgress); stalled > s.stallAfter {
+		return fmt.Errorf("no progress for %s", stalled.Round(time.Second))
+	}
+	return nil
+}
+
+// LivenessHandler serves /healthz
+func (s *Status) LivenessHandler() http.HandlerFunc {
+	return probeHandler(s.Alive, s.Degraded)
+}
+
+// ReadinessHandler serves /readyz
+func (s *Status) ReadinessHandler() http.HandlerFunc {
+	return probeHandler(s.Ready, s.Degraded)
+}
+
+// probeHandler responds with 503 when the probe fails, a degraded sidecar still passes the probes
+func probeHandler(probe func() error, degraded func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := probe(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		if err := degraded(); err != nil {
+			fmt.Fprintln(w, "degraded:", err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
@@ -0,0 +1,50 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	s := New(time.Minute, 10*time.Minute)
+	s.now = func() time.Time { return now }
+	s.lastProgress = now
+
+	probe := func(handler http.HandlerFunc) int {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("GET", "/", nil))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, probe(s.LivenessHandler()))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(s.ReadinessHandler()))
+
+	s.SetPIDFound()
+	s.SetBaselineSaved()
+	assert.Error(t, s.Ready())
+	s.CheckCompleted()
+	assert.Equal(t, http.StatusOK, probe(s.ReadinessHandler()))
+
+	s.SetDegraded(errors.New("database is unavailable"))
+	assert.Equal(t, http.StatusOK, probe(s.ReadinessHandler()))
+	assert.Error(t, s.Degraded())
+	// Retrying keeps the sidecar alive only for the longest degraded period
+	now = now.Add(5 * time.Minute)
+	s.SetDegraded(errors.New("database is still unavailable"))
+	assert.Equal(t, http.StatusOK, probe(s.LivenessHandler()))
+	now = now.Add(6 * time.Minute)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(s.LivenessHandler()))
+	s.SetDegraded(nil)
+	assert.Equal(t, http.StatusOK, probe(s.LivenessHandler()))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(s.LivenessHandler()))
+	s.CheckCompleted()
+	assert.Equal(t, http.StatusOK, probe(s.LivenessHandler()))
+}
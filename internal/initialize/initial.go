@@ -2,72 +2,199 @@ package initialize
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
+	"github.com/integrity-sum/internal/configs"
+	"github.com/integrity-sum/internal/core/models"
 	"github.com/integrity-sum/internal/core/services"
+	"github.com/integrity-sum/internal/health"
+	"github.com/integrity-sum/internal/metrics"
 	"github.com/integrity-sum/internal/repositories"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/hasher"
+	"github.com/integrity-sum/pkg/retry"
 	"github.com/sirupsen/logrus"
 )
 
-func Initialize(ctx context.Context, logger *logrus.Logger, sig chan os.Signal) {
+const (
+	// stallChecks is the number of check intervals without progress after which the liveness probe fails
+	stallChecks = 3
+	// defaultMaxDegraded is the number of seconds of retrying a failed operation after which the liveness probe fails
+	defaultMaxDegraded = 600
+)
+
+// Initialize runs the sidecar until the context is done. Failures of the database, the Kubernetes API
+// and the monitored process are retried with a backoff, only configuration errors are returned
+func Initialize(ctx context.Context, logger *logrus.Logger) error {
+	duration, err := strconv.Atoi(os.Getenv("DURATION_TIME"))
+	if err != nil {
+		duration = 15
+	}
+	maxDegraded, err := strconv.Atoi(os.Getenv("MAX_DEGRADED_TIME"))
+	if err != nil {
+		maxDegraded = defaultMaxDegraded
+	}
+	// The sidecar is not alive when it made no progress for a few check intervals or failed for too long
+	status := health.New(time.Duration(stallChecks*duration)*time.Second, time.Duration(maxDegraded)*time.Second)
+	startHTTPServer(ctx, logger, status)
+
+	s := &supervisor{logger: logger, status: status, backoff: retry.DefaultBackoff}
+	err = run(ctx, s, time.Duration(duration)*time.Second)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	logger.Info("Monitoring stopped")
+	return nil
+}
+
+func run(ctx context.Context, s *supervisor, interval time.Duration) error {
+	logger := s.logger
+
 	// Initialize repository
-	repository := repositories.NewAppRepository(logger)
+	var repository *repositories.AppRepository
+	err := s.retry(ctx, "Connecting to the database", func() (err error) {
+		repository, err = repositories.NewAppRepository(logger)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("can't init repository: %w", err)
+	}
+	defer func() {
+		if err := repository.Close(); err != nil {
+			logger.Errorf("error while closing repository %s", err)
+		}
+	}()
 
 	// Initialize service
-	algorithm := os.Getenv("ALGORITHM")
-
-	service := services.NewAppService(repository, algorithm, logger)
+	algorithm := strings.ToUpper(os.Getenv("ALGORITHM"))
+	if algorithm == "" {
+		algorithm = hasher.DefaultAlgorithm
+	}
+	if _, err := hasher.NewHashSum(algorithm); err != nil {
+		return fmt.Errorf("%w: ALGORITHM %s", configs.ErrInvalidConfig, err)
+	}
+	// The baseline saved with PREVIOUS_ALGORITHM is migrated to ALGORITHM
+	previousAlgorithm := strings.ToUpper(os.Getenv("PREVIOUS_ALGORITHM"))
+	if previousAlgorithm != "" {
+		if _, err := hasher.NewHashSum(previousAlgorithm); err != nil {
+			return fmt.Errorf("%w: PREVIOUS_ALGORITHM %s", configs.ErrInvalidConfig, err)
+		}
+	}
 
-	// Initialize kubernetesAPI
-	dataFromK8sAPI, err := service.GetDataFromK8sAPI()
+	symlinks, err := api.ParseSymlinks(os.Getenv("SYMLINKS"))
 	if err != nil {
-		logger.Fatalf("can't get data from K8sAPI: %s", err)
+		return fmt.Errorf("%w: SYMLINKS %s", configs.ErrInvalidConfig, err)
 	}
 
-	//Getting pid
-	pid, err := service.GetPID(dataFromK8sAPI.ConfigMapData)
+	service := services.NewAppService(repository, algorithm, previousAlgorithm, symlinks, logger)
+
+	// Initialize kubernetesAPI
+	var dataFromK8sAPI *models.DataFromK8sAPI
+	err = s.retry(ctx, "Getting data from K8sAPI", func() (err error) {
+		dataFromK8sAPI, err = service.GetDataFromK8sAPI()
+		return err
+	})
 	if err != nil {
-		logger.Fatalf("err while getting pid %s", err)
+		return fmt.Errorf("can't get data from K8sAPI: %w", err)
 	}
-	if pid == 0 {
-		logger.Fatalf("proc with name %s not exist", dataFromK8sAPI.ConfigMapData.ProcName)
+	metrics.SetTarget(dataFromK8sAPI.KuberData.Namespace, dataFromK8sAPI.KuberData.TargetName, dataFromK8sAPI.KuberData.Pod.Name)
+
+	//Getting the pid and the root of the filesystem of the process of every container
+	containers := dataFromK8sAPI.Containers
+	for _, container := range containers {
+		err = s.retry(ctx, "Finding the monitored process of "+container.DeploymentData.ContainerName, func() error {
+			return service.TrackProcess(container, dataFromK8sAPI.KuberData)
+		})
+		if err != nil {
+			return err
+		}
 	}
+	status := s.status
+	status.SetPIDFound()
 
-	//Getting the path to the monitoring directory
-	dirPath := "../proc/" + strconv.Itoa(pid) + "/root/" + dataFromK8sAPI.ConfigMapData.MountPath
+	baselineSource := os.Getenv("BASELINE_SOURCE")
+	if baselineSource == "" {
+		baselineSource = services.BaselineSourceAuto
+	}
 
-	duration, err := strconv.Atoi(os.Getenv("DURATION_TIME"))
+	watchEvents, err := strconv.ParseBool(os.Getenv("WATCH_EVENTS"))
 	if err != nil {
-		duration = 15
+		watchEvents = true
+	}
+
+	// Every container of the pod has its own baseline, a container whose baseline failed to save gets it on the next start
+	for _, container := range containers {
+		containerName := container.DeploymentData.ContainerName
+		var found bool
+		err = s.retry(ctx, "Looking for the baseline of "+containerName, func() (err error) {
+			found, err = service.HasBaseline(container)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			logger.Infof("Baseline of %s exists in database, checking data", containerName)
+			continue
+		}
+		logger.Infof("Baseline of %s does not exist in database, save data", containerName)
+		err = s.retry(ctx, "Saving the baseline of "+containerName, func() error {
+			return saveBaseline(ctx, service, container, baselineSource, logger)
+		})
+		if err != nil {
+			return err
+		}
 	}
-	ticker := time.NewTicker(time.Duration(duration) * time.Second)
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func(ctx context.Context, ticker *time.Ticker) {
-		defer wg.Done()
-		for {
-			if service.IsExistDeploymentNameInDB(dataFromK8sAPI.KuberData.TargetName) {
-				logger.Info("Deployment name does not exist in database, save data")
-				err := service.Start(ctx, dirPath, sig, dataFromK8sAPI.DeploymentData)
-				if err != nil {
-					logger.Fatalf("Error when starting to get and save hash data %s", err)
-				}
-			} else {
-				logger.Info("Deployment name exists in database, checking data")
-				for range ticker.C {
-					err := service.Check(ctx, dirPath, sig, dataFromK8sAPI.DeploymentData, dataFromK8sAPI.KuberData)
-					if err != nil {
-						logger.Fatalf("Error when starting to check hash data %s", err)
-					}
-					logger.Info("Check completed")
-				}
+	status.SetBaselineSaved()
+
+	if watchEvents {
+		go func() {
+			err := service.Watch(ctx, containers, dataFromK8sAPI.KuberData)
+			if err != nil {
+				logger.Errorf("Watching for changes stopped, only periodic checks are running %s", err)
 			}
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
-	}(ctx, ticker)
-	wg.Wait()
-	ticker.Stop()
+		err := s.retry(ctx, "Checking hash data", func() error {
+			return service.Check(ctx, containers, dataFromK8sAPI.KuberData)
+		})
+		if err != nil {
+			return err
+		}
+		status.CheckCompleted()
+		logger.Info("Check completed")
+	}
+}
+
+// saveBaseline saves the baseline of the container computed from its image or from the files found on the first run
+func saveBaseline(ctx context.Context, service *services.AppService, container *models.ContainerData, baselineSource string, logger *logrus.Logger) error {
+	if baselineSource != services.BaselineSourceRuntime {
+		found, err := service.StartFromImage(container)
+		if err != nil {
+			return fmt.Errorf("error when saving baseline from the image %w", err)
+		}
+		if found {
+			logger.Infof("Baseline computed from the image %s is used for %s", container.DeploymentData.ImageDigest, container.DeploymentData.ContainerName)
+			return nil
+		}
+		if baselineSource == services.BaselineSourceImage {
+			// The baseline may still be computed with cmd/image-baseline, so it is retried
+			return fmt.Errorf("there is no baseline for the image %s, compute it with cmd/image-baseline", container.DeploymentData.ImageDigest)
+		}
+	}
+	return service.Start(ctx, container)
 }
@@ -0,0 +1,51 @@
+package initialize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/integrity-sum/internal/health"
+	"github.com/integrity-sum/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHTTPAddr     = ":9090"
+	shutdownHTTPTimeout = 5 * time.Second
+)
+
+// startHTTPServer serves the metrics and the probes on HTTP_ADDR until the context is done
+func startHTTPServer(ctx context.Context, logger *logrus.Logger, status *health.Status) {
+	addr := os.Getenv("HTTP_ADDR")
+	if addr == "" {
+		addr = defaultHTTPAddr
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", status.LivenessHandler())
+	mux.Handle("/readyz", status.ReadinessHandler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logger.Infof("Serving metrics and probes on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("HTTP server stopped %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownHTTPTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("error while stopping HTTP server %s", err)
+		}
+	}()
+}
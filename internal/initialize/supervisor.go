@@ -0,0 +1,42 @@
+package initialize
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/integrity-sum/internal/configs"
+	"github.com/integrity-sum/internal/health"
+	"github.com/integrity-sum/internal/metrics"
+	"github.com/integrity-sum/pkg/retry"
+	"github.com/sirupsen/logrus"
+)
+
+// supervisor retries the failed steps of the sidecar and keeps the degraded status
+type supervisor struct {
+	logger  *logrus.Logger
+	status  *health.Status
+	backoff retry.Backoff
+}
+
+// retry calls fn until it succeeds, the context is done or it fails with a configuration error.
+// The sidecar is degraded while fn is retried
+func (s *supervisor) retry(ctx context.Context, operation string, fn func() error) error {
+	err := retry.Do(ctx, s.backoff, func() error {
+		err := fn()
+		if errors.Is(err, configs.ErrInvalidConfig) || (err != nil && ctx.Err() != nil) {
+			return retry.Permanent(err)
+		}
+		return err
+	}, func(failed int, err error, delay time.Duration) {
+		s.logger.Warnf("%s failed (attempt %d), retrying in %s: %s", operation, failed, delay.Round(time.Millisecond), err)
+		s.status.SetDegraded(err)
+		metrics.SetDegraded(true)
+	})
+	if err == nil && s.status.Degraded() != nil {
+		s.logger.Infof("%s succeeded, the sidecar recovered", operation)
+		s.status.SetDegraded(nil)
+		metrics.SetDegraded(false)
+	}
+	return err
+}
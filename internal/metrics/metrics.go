@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "integrity_sum"
+
+// Triggers of a scan
+const (
+	TriggerPeriodic = "periodic"
+	TriggerWatch    = "watch"
+)
+
+var targetLabels = []string{"namespace", "workload", "pod"}
+
+var registry = prometheus.NewRegistry()
+
+var (
+	scansTotal = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scans_total",
+		Help:      "Number of integrity checks run.",
+	}, append(targetLabels, "trigger"))
+	scanDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scan_duration_seconds",
+		Help:      "Duration of integrity checks.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, append(targetLabels, "trigger"))
+	filesHashed = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_hashed_total",
+		Help:      "Number of files hashed.",
+	}, targetLabels)
+	bytesHashed = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_hashed_total",
+		Help:      "Number of bytes read while hashing files.",
+	}, targetLabels)
+	hashErrors = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hash_errors_total",
+		Help:      "Number of files that could not be hashed.",
+	}, targetLabels)
+	databaseErrors = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_errors_total",
+		Help:      "Number of failed database operations.",
+	}, append(targetLabels, "operation"))
+	violations = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "violations_total",
+		Help:      "Number of changed files found, by type of the change.",
+	}, append(targetLabels, "type"))
+	degraded = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "degraded",
+		Help:      "1 while a failed database or Kubernetes API operation is being retried.",
+	}, targetLabels)
+	remediations = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "remediations_total",
+		Help:      "Number of restarts and rollbacks triggered.",
+	}, append(targetLabels, "remediation"))
+	processRestarts = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "process_restarts_total",
+		Help:      "Number of times the monitored process was replaced.",
+	}, append(targetLabels, "container"))
+	cacheHits = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hash_cache_hits_total",
+		Help:      "Number of files whose hash sum was taken from the stat cache without reading them.",
+	}, targetLabels)
+	cacheMisses = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hash_cache_misses_total",
+		Help:      "Number of files read because they changed, are new or a full re-read was forced.",
+	}, targetLabels)
+)
+
+var (
+	mu     sync.RWMutex
+	target = prometheus.Labels{"namespace": "", "workload": "", "pod": ""}
+)
+
+func init() {
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// SetTarget sets the namespace, workload and pod labels of all metrics
+func SetTarget(namespace, workload, pod string) {
+	mu.Lock()
+	defer mu.Unlock()
+	target = prometheus.Labels{"namespace": namespace, "workload": workload, "pod": pod}
+}
+
+// labels returns the target labels with the additional name and value pairs
+func labels(pairs ...string) prometheus.Labels {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make(prometheus.Labels, len(target)+len(pairs)/2)
+	for name, value := range target {
+		result[name] = value
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result[pairs[i]] = pairs[i+1]
+	}
+	return result
+}
+
+// ObserveScan counts a completed check and its duration
+func ObserveScan(trigger string, duration time.Duration) {
+	l := labels("trigger", trigger)
+	scansTotal.With(l).Inc()
+	scanDuration.With(l).Observe(duration.Seconds())
+}
+
+// AddHashedFile counts a hashed file and its size
+func AddHashedFile(size int64) {
+	l := labels()
+	filesHashed.With(l).Inc()
+	bytesHashed.With(l).Add(float64(size))
+}
+
+// IncHashErrors counts a file that could not be hashed
+func IncHashErrors() {
+	hashErrors.With(labels()).Inc()
+}
+
+// IncDatabaseErrors counts a failed database operation
+func IncDatabaseErrors(operation string) {
+	databaseErrors.With(labels("operation", operation)).Inc()
+}
+
+// AddViolations counts changed files of the type
+func AddViolations(changeType string, count int) {
+	if count == 0 {
+		return
+	}
+	violations.With(labels("type", changeType)).Add(float64(count))
+}
+
+// IncRemediations counts a triggered restart or rollback
+func IncRemediations(remediation string) {
+	remediations.With(labels("remediation", remediation)).Inc()
+}
+
+// IncProcessRestarts counts a replaced monitored process of the container
+func IncProcessRestarts(container string) {
+	processRestarts.With(labels("container", container)).Inc()
+}
+
+// AddCacheResults counts the hits and the misses of the stat cache in a scan
+func AddCacheResults(hits, misses int) {
+	l := labels()
+	cacheHits.With(l).Add(float64(hits))
+	cacheMisses.With(l).Add(float64(misses))
+}
+
+// SetDegraded sets the degraded gauge
+func SetDegraded(isDegraded bool) {
+	value := 0.0
+	if isDegraded {
+		value = 1
+	}
+	degraded.With(labels()).Set(value)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
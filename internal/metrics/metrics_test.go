@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	SetTarget("default", "app", "app-0")
+	ObserveScan(TriggerPeriodic, time.Second)
+	AddHashedFile(10)
+	AddHashedFile(5)
+	AddViolations("modified", 2)
+	AddViolations("added", 0)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(scansTotal.With(labels("trigger", TriggerPeriodic))))
+	assert.Equal(t, 2.0, testutil.ToFloat64(filesHashed.With(labels())))
+	assert.Equal(t, 15.0, testutil.ToFloat64(bytesHashed.With(labels())))
+	assert.Equal(t, 2.0, testutil.ToFloat64(violations.With(labels("type", "modified"))))
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	assert.True(t, strings.Contains(body, `integrity_sum_files_hashed_total{namespace="default",pod="app-0",workload="app"} 2`))
+	assert.NotContains(t, body, `type="added"`)
+}
@@ -0,0 +1,17 @@
+package notifiers
+
+import (
+	"os"
+
+	"github.com/integrity-sum/internal/core/ports"
+	"github.com/sirupsen/logrus"
+)
+
+// New creates the notifiers configured with environment variables
+func New(logger *logrus.Logger) []ports.INotifier {
+	var notifiers []ports.INotifier
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(url, logger))
+	}
+	return notifiers
+}
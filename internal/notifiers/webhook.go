@@ -0,0 +1,107 @@
+package notifiers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/sirupsen/logrus"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhookChange is a difference as it is sent in the body of the webhook
+type webhookChange struct {
+	Type         string `json:"type"`
+	FullFilePath string `json:"full_file_path"`
+	OldHash      string `json:"old_hash,omitempty"`
+	NewHash      string `json:"new_hash,omitempty"`
+	OldImage     string `json:"old_image,omitempty"`
+	NewImage     string `json:"new_image,omitempty"`
+	// Reasons lists the attributes of a modified file which differ from the baseline
+	Reasons []webhookReason `json:"reasons,omitempty"`
+}
+
+// webhookReason is an attribute of a modified file as it is sent in the body of the webhook
+type webhookReason struct {
+	Attribute string `json:"attribute"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+}
+
+// webhookPayload is the JSON body posted to the webhook
+type webhookPayload struct {
+	DetectedAt  time.Time       `json:"detected_at"`
+	Severity    string          `json:"severity"`
+	Namespace   string          `json:"namespace"`
+	Workload    string          `json:"workload"`
+	Kind        string          `json:"kind"`
+	Pod         string          `json:"pod"`
+	Image       string          `json:"image"`
+	Remediation string          `json:"remediation"`
+	Changes     []webhookChange `json:"changes"`
+}
+
+// WebhookNotifier posts violations as JSON to an HTTP endpoint
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+	logger *logrus.Logger
+}
+
+// NewWebhookNotifier creates a new struct WebhookNotifier
+func NewWebhookNotifier(url string, logger *logrus.Logger) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger,
+	}
+}
+
+// Notify posts the violation to the webhook
+func (wn *WebhookNotifier) Notify(violation *models.Violation) error {
+	payload := webhookPayload{
+		DetectedAt:  violation.DetectedAt,
+		Severity:    string(violation.Severity),
+		Namespace:   violation.Namespace,
+		Workload:    violation.Workload,
+		Kind:        violation.Kind,
+		Pod:         violation.Pod,
+		Image:       violation.Image,
+		Remediation: violation.Remediation,
+	}
+	for _, change := range violation.Report.Changes {
+		webhookChange := webhookChange{
+			Type:         string(change.Type),
+			FullFilePath: change.FullFilePath,
+			OldHash:      change.OldHash,
+			NewHash:      change.NewHash,
+			OldImage:     change.OldImage,
+			NewImage:     change.NewImage,
+		}
+		for _, reason := range change.Reasons {
+			webhookChange.Reasons = append(webhookChange.Reasons, webhookReason{Attribute: reason.Attribute, Old: reason.Old, New: reason.New})
+		}
+		payload.Changes = append(payload.Changes, webhookChange)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := wn.client.Post(wn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		wn.logger.Error("err while sending webhook ", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("webhook responded with status %s", resp.Status)
+		wn.logger.Error(err)
+		return err
+	}
+	return nil
+}
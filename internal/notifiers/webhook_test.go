@@ -0,0 +1,55 @@
+package notifiers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	testTable := []struct {
+		name          string
+		status        int
+		expectedError bool
+	}{
+		{name: "accepted", status: http.StatusNoContent},
+		{name: "rejected", status: http.StatusInternalServerError, expectedError: true},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var payload webhookPayload
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+				w.WriteHeader(testCase.status)
+			}))
+			defer server.Close()
+
+			err := NewWebhookNotifier(server.URL, logrus.New()).Notify(&models.Violation{
+				DetectedAt:  time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+				Severity:    models.SeverityInfo,
+				Workload:    "app",
+				Remediation: "observe",
+				Report: &models.DiffReport{Changes: []*models.FileChange{
+					{Type: models.ChangeModified, FullFilePath: "/etc/nginx/nginx.conf", OldHash: "1", NewHash: "2"},
+				}},
+			})
+			if testCase.expectedError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, "app", payload.Workload)
+			assert.Equal(t, "info", payload.Severity)
+			assert.Equal(t, []webhookChange{{Type: "modified", FullFilePath: "/etc/nginx/nginx.conf", OldHash: "1", NewHash: "2"}}, payload.Changes)
+		})
+	}
+}
@@ -2,44 +2,68 @@ package repositories
 
 import (
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/integrity-sum/internal/configs"
 	"github.com/integrity-sum/internal/core/ports"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// DriverPostgres stores hash data in an external PostgreSQL database
+	DriverPostgres = "postgres"
+	// DriverBolt stores hash data in an embedded bbolt database file
+	DriverBolt = "bolt"
+)
+
 type AppRepository struct {
 	ports.IHashRepository
+	ports.IAppRepository
+	closer io.Closer
 	logger *logrus.Logger
 }
 
-func NewAppRepository(logger *logrus.Logger) *AppRepository {
-	return &AppRepository{
-		IHashRepository: NewHashRepository(logger),
-		logger:          logger,
+// NewAppRepository creates a new struct AppRepository with the storage backend selected by DB_DRIVER
+func NewAppRepository(logger *logrus.Logger) (*AppRepository, error) {
+	driver := os.Getenv("DB_DRIVER")
+	switch driver {
+	case DriverBolt:
+		boltRepository, err := NewBoltRepository(os.Getenv("DB_PATH"), logger)
+		if err != nil {
+			logger.Errorf("failed to open bolt database %s", err)
+			return nil, err
+		}
+		return &AppRepository{
+			IHashRepository: boltRepository,
+			IAppRepository:  boltRepository,
+			closer:          boltRepository,
+			logger:          logger,
+		}, nil
+	case DriverPostgres, "":
+		db, err := ConnectionToDB(logger)
+		if err != nil {
+			logger.Errorf("failed to connection to database %s", err)
+			return nil, err
+		}
+		if err := Migrate(db, os.Getenv("TABLE_NAME"), logger); err != nil {
+			logger.Errorf("failed to migrate database schema %s", err)
+			db.Close()
+			return nil, err
+		}
+		hashRepository := NewHashRepository(db, logger)
+		return &AppRepository{
+			IHashRepository: hashRepository,
+			IAppRepository:  hashRepository,
+			closer:          hashRepository,
+			logger:          logger,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported database driver %q", configs.ErrInvalidConfig, driver)
 	}
 }
 
-// IsExistDeploymentNameInDB checks if the base is empty
-func (ar AppRepository) IsExistDeploymentNameInDB(deploymentName string) (bool, error) {
-	db, err := ConnectionToDB(ar.logger)
-	if err != nil {
-		ar.logger.Error("failed to connection to database %s", err)
-		return false, err
-	}
-	defer db.Close()
-
-	var count int
-	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE name_deployment=$1 LIMIT 1;", os.Getenv("TABLE_NAME"))
-	row := db.QueryRow(query, deploymentName)
-	err = row.Scan(&count)
-	if err != nil {
-		ar.logger.Error("err while scan row in database ", err)
-		return false, err
-	}
-
-	if count < 1 {
-		return true, nil
-	}
-	return false, nil
+// Close releases the connections held by the storage backend
+func (ar *AppRepository) Close() error {
+	return ar.closer.Close()
 }
@@ -0,0 +1,547 @@
+package repositories
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultBoltPath is used when DB_PATH is not set
+	DefaultBoltPath = "/var/lib/integrity-sum/integrity-sum.db"
+	// boltSchemaVersion is the layout version of the buckets written by this binary
+	boltSchemaVersion = 2
+	// boltProcessRelativeVersion is the version which stores the paths of the files as the process sees them
+	boltProcessRelativeVersion = 2
+)
+
+var (
+	boltVersionKey = []byte("version")
+	// procRootPrefix matches the root of a process the paths were saved with before they were process-relative
+	procRootPrefix = regexp.MustCompile(`^(\.\./|/)proc/[0-9]+/root/`)
+)
+
+// boltRecord is a row of the hash table as it is stored in the bolt database
+type boltRecord struct {
+	ID             int    `json:"id"`
+	FileName       string `json:"file_name"`
+	FullFilePath   string `json:"full_file_path"`
+	MountPath      string `json:"mount_path"`
+	Hash           string `json:"hash_sum"`
+	Algorithm      string `json:"algorithm"`
+	NamePod        string `json:"name_pod"`
+	ImageContainer string `json:"image_tag"`
+	ImageDigest    string `json:"image_digest,omitempty"`
+	TimeOfCreation string `json:"time_of_creation"`
+	NameDeployment string `json:"name_deployment"`
+	ContainerName  string `json:"container_name,omitempty"`
+	FileType       string `json:"file_type,omitempty"`
+	FileMode       uint32 `json:"file_mode,omitempty"`
+	UID            int    `json:"uid,omitempty"`
+	GID            int    `json:"gid,omitempty"`
+	FileSize       int64  `json:"file_size,omitempty"`
+	LinkTarget     string `json:"link_target,omitempty"`
+}
+
+// newBoltRecord creates a record with the metadata of the hash data
+func newBoltRecord(hash *api.HashData) boltRecord {
+	return boltRecord{
+		FileName:     hash.FileName,
+		FullFilePath: hash.FullFilePath,
+		MountPath:    hash.MountPath,
+		Hash:         hash.Hash,
+		Algorithm:    hash.Algorithm,
+		FileType:     hash.Type,
+		FileMode:     hash.Mode,
+		UID:          hash.UID,
+		GID:          hash.GID,
+		FileSize:     hash.Size,
+		LinkTarget:   hash.LinkTarget,
+	}
+}
+
+// inMountPath reports whether the record belongs to the mount path. Records saved before mount paths were
+// recorded have an empty one and belong to the mount path their file is under
+func (r boltRecord) inMountPath(mountPath string) bool {
+	if r.MountPath != "" || mountPath == "" {
+		return r.MountPath == mountPath
+	}
+	dir := "/" + mountPath
+	return r.FullFilePath == dir || strings.HasPrefix(r.FullFilePath, dir+"/")
+}
+
+// metadata returns the metadata of the file saved in the record
+func (r boltRecord) metadata() api.Metadata {
+	return api.Metadata{Type: r.FileType, Mode: r.FileMode, UID: r.UID, GID: r.GID, Size: r.FileSize, LinkTarget: r.LinkTarget}
+}
+
+// boltChange is a row of the changes table as it is stored in the bolt database
+type boltChange struct {
+	ID           int       `json:"id"`
+	DetectedAt   time.Time `json:"detected_at"`
+	Namespace    string    `json:"namespace"`
+	NamePod      string    `json:"name_pod"`
+	Remediation  string    `json:"remediation"`
+	ChangeType   string    `json:"change_type"`
+	FullFilePath string    `json:"full_file_path"`
+	OldHash      string    `json:"old_hash,omitempty"`
+	NewHash      string    `json:"new_hash,omitempty"`
+	OldImage     string    `json:"old_image,omitempty"`
+	NewImage     string    `json:"new_image,omitempty"`
+	Reasons      string    `json:"reasons,omitempty"`
+}
+
+// BoltRepository keeps hash data in an embedded bbolt database file.
+// Rows are grouped in a bucket per deployment inside a bucket named after TABLE_NAME,
+// baselines computed from images in a bucket per image digest inside <TABLE_NAME>_images,
+// recorded violations in a bucket per deployment inside <TABLE_NAME>_changes.
+type BoltRepository struct {
+	db     *bolt.DB
+	table  []byte
+	logger *logrus.Logger
+}
+
+// NewBoltRepository opens (or creates) the bolt database file at the given path
+func NewBoltRepository(path string, logger *logrus.Logger) (*BoltRepository, error) {
+	if path == "" {
+		path = DefaultBoltPath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Connected to the database ", DriverBolt, " ", path)
+
+	table := os.Getenv("TABLE_NAME")
+	if table == "" {
+		table = "hashfiles"
+	}
+
+	br := &BoltRepository{
+		db:     db,
+		table:  []byte(table),
+		logger: logger,
+	}
+	if err := br.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return br, nil
+}
+
+// migrate records the layout version in the <table>_schema_version bucket, upgrades the records
+// written by an older binary and refuses to work with a database written by a newer binary
+func (br *BoltRepository) migrate() error {
+	return br.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(string(br.table) + "_schema_version"))
+		if err != nil {
+			return err
+		}
+		version := 0
+		if value := meta.Get(boltVersionKey); value != nil {
+			version = int(binary.BigEndian.Uint64(value))
+		}
+		if version > boltSchemaVersion {
+			return &ErrSchemaTooNew{DBVersion: version, BinaryVersion: boltSchemaVersion}
+		}
+		table, err := tx.CreateBucketIfNotExists(br.table)
+		if err != nil {
+			return err
+		}
+		if version < boltProcessRelativeVersion {
+			if err := processRelativePaths(table); err != nil {
+				return err
+			}
+		}
+		return meta.Put(boltVersionKey, boltKey(boltSchemaVersion))
+	})
+}
+
+// processRelativePaths removes the root of the process from the paths of the records saved
+// with /proc/<pid>/root/etc/nginx/nginx.conf instead of /etc/nginx/nginx.conf
+func processRelativePaths(table *bolt.Bucket) error {
+	return table.ForEach(func(name, value []byte) error {
+		if value != nil {
+			return errors.New("unexpected value in the root bucket")
+		}
+		deployment := table.Bucket(name)
+		updated := make(map[string][]byte)
+		err := deployment.ForEach(func(key, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if !procRootPrefix.MatchString(record.FullFilePath) {
+				return nil
+			}
+			record.FullFilePath = procRootPrefix.ReplaceAllString(record.FullFilePath, "/")
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			updated[string(key)] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// A bucket must not be modified while it is iterated
+		for key, value := range updated {
+			if err := deployment.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close releases the database file
+func (br *BoltRepository) Close() error {
+	return br.db.Close()
+}
+
+// IsExistDeploymentNameInDB checks if the base is empty
+func (br *BoltRepository) IsExistDeploymentNameInDB(deploymentName string) (bool, error) {
+	isEmpty := true
+	err := br.db.View(func(tx *bolt.Tx) error {
+		table := tx.Bucket(br.table)
+		if table == nil {
+			return nil
+		}
+		deployment := table.Bucket([]byte(deploymentName))
+		if deployment == nil {
+			return nil
+		}
+		k, _ := deployment.Cursor().First()
+		isEmpty = k == nil
+		return nil
+	})
+	if err != nil {
+		br.logger.Error("err while reading bolt database ", err)
+		return false, err
+	}
+	return isEmpty, nil
+}
+
+// SaveHashData saves all elements of the slice in one transaction
+func (br *BoltRepository) SaveHashData(allHashData []*api.HashData, deploymentData *models.DeploymentData) error {
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		table, err := tx.CreateBucketIfNotExists(br.table)
+		if err != nil {
+			return err
+		}
+		deployment, err := table.CreateBucketIfNotExists([]byte(deploymentData.NameDeployment))
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range allHashData {
+			id, err := deployment.NextSequence()
+			if err != nil {
+				return err
+			}
+			record := newBoltRecord(hash)
+			record.ID = int(id)
+			record.NamePod = deploymentData.NamePod
+			record.ImageContainer = deploymentData.Image
+			record.TimeOfCreation = deploymentData.Timestamp
+			record.NameDeployment = deploymentData.NameDeployment
+			record.ContainerName = deploymentData.ContainerName
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := deployment.Put(boltKey(id), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		br.logger.Error("err while save data in database ", err)
+		return err
+	}
+	return nil
+}
+
+// GetHashData retrieves the baseline of the mount path of the container from the database using the algorithm
+func (br *BoltRepository) GetHashData(mountPath, algorithm string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error) {
+	var allHashDataFromDB []*models.HashDataFromDB
+
+	err := br.db.View(func(tx *bolt.Tx) error {
+		table := tx.Bucket(br.table)
+		if table == nil {
+			return nil
+		}
+		return table.ForEach(func(name, value []byte) error {
+			if value != nil {
+				return errors.New("unexpected value in the root bucket")
+			}
+			return table.Bucket(name).ForEach(func(_, value []byte) error {
+				var record boltRecord
+				if err := json.Unmarshal(value, &record); err != nil {
+					return err
+				}
+				if !record.inMountPath(mountPath) || record.Algorithm != algorithm || record.NamePod != deploymentData.NamePod || record.ContainerName != deploymentData.ContainerName {
+					return nil
+				}
+				allHashDataFromDB = append(allHashDataFromDB, &models.HashDataFromDB{
+					ID:             record.ID,
+					Hash:           record.Hash,
+					FileName:       record.FileName,
+					FullFilePath:   record.FullFilePath,
+					MountPath:      record.MountPath,
+					Algorithm:      record.Algorithm,
+					ImageContainer: record.ImageContainer,
+					NamePod:        record.NamePod,
+					NameDeployment: record.NameDeployment,
+					Metadata:       record.metadata(),
+				})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		br.logger.Error(err)
+		return nil, err
+	}
+
+	return allHashDataFromDB, nil
+}
+
+// DeleteFromTable removes data from the table that matches the name of the deployment
+func (br *BoltRepository) DeleteFromTable(nameDeployment string) error {
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(br.table)
+		if table == nil || table.Bucket([]byte(nameDeployment)) == nil {
+			return nil
+		}
+		return table.DeleteBucket([]byte(nameDeployment))
+	})
+	if err != nil {
+		br.logger.Error("err while deleting rows in database", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteOtherAlgorithms removes the baseline of the container of the pod saved with algorithms other than the given one
+func (br *BoltRepository) DeleteOtherAlgorithms(algorithm string, deploymentData *models.DeploymentData) error {
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(br.table)
+		if table == nil {
+			return nil
+		}
+		deployment := table.Bucket([]byte(deploymentData.NameDeployment))
+		if deployment == nil {
+			return nil
+		}
+		var keys [][]byte
+		err := deployment.ForEach(func(key, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if record.Algorithm != algorithm && record.NamePod == deploymentData.NamePod && record.ContainerName == deploymentData.ContainerName {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Keys are deleted after the iteration, deleting during ForEach skips records
+		for _, key := range keys {
+			if err := deployment.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		br.logger.Error("err while deleting rows in database", err)
+		return err
+	}
+	return nil
+}
+
+// SaveImageBaseline replaces the baseline computed from the image with the given digest
+func (br *BoltRepository) SaveImageBaseline(imageDigest, imageName string, allHashData []*api.HashData) error {
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		images, err := tx.CreateBucketIfNotExists(br.imagesBucket())
+		if err != nil {
+			return err
+		}
+		image, err := images.CreateBucketIfNotExists([]byte(imageDigest))
+		if err != nil {
+			return err
+		}
+
+		algorithms := make(map[string]struct{})
+		for _, hash := range allHashData {
+			algorithms[hash.Algorithm] = struct{}{}
+		}
+		var stale [][]byte
+		err = image.ForEach(func(key, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if _, ok := algorithms[record.Algorithm]; ok {
+				stale = append(stale, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := image.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		timestamp := time.Now().UTC().Format(time.RFC3339)
+		for _, hash := range allHashData {
+			id, err := image.NextSequence()
+			if err != nil {
+				return err
+			}
+			record := newBoltRecord(hash)
+			record.ID = int(id)
+			record.ImageContainer = imageName
+			record.ImageDigest = imageDigest
+			record.TimeOfCreation = timestamp
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := image.Put(boltKey(id), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		br.logger.Error("err while save data in database ", err)
+		return err
+	}
+	return nil
+}
+
+// GetImageBaseline retrieves the baseline computed from the image with the given digest
+func (br *BoltRepository) GetImageBaseline(imageDigest, algorithm string) ([]*models.HashDataFromDB, error) {
+	var allHashDataFromDB []*models.HashDataFromDB
+
+	err := br.db.View(func(tx *bolt.Tx) error {
+		images := tx.Bucket(br.imagesBucket())
+		if images == nil {
+			return nil
+		}
+		image := images.Bucket([]byte(imageDigest))
+		if image == nil {
+			return nil
+		}
+		return image.ForEach(func(_, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if record.Algorithm != algorithm {
+				return nil
+			}
+			allHashDataFromDB = append(allHashDataFromDB, &models.HashDataFromDB{
+				ID:             record.ID,
+				Hash:           record.Hash,
+				FileName:       record.FileName,
+				FullFilePath:   record.FullFilePath,
+				Algorithm:      record.Algorithm,
+				ImageContainer: record.ImageContainer,
+				ImageDigest:    record.ImageDigest,
+				Metadata:       record.metadata(),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		br.logger.Error(err)
+		return nil, err
+	}
+
+	return allHashDataFromDB, nil
+}
+
+// SaveViolation saves every difference of the violation in the bucket of the deployment
+func (br *BoltRepository) SaveViolation(violation *models.Violation) error {
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		changes, err := tx.CreateBucketIfNotExists(br.changesBucket())
+		if err != nil {
+			return err
+		}
+		deployment, err := changes.CreateBucketIfNotExists([]byte(violation.Workload))
+		if err != nil {
+			return err
+		}
+
+		for _, change := range violation.Report.Changes {
+			id, err := deployment.NextSequence()
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(boltChange{
+				ID:           int(id),
+				DetectedAt:   violation.DetectedAt,
+				Namespace:    violation.Namespace,
+				NamePod:      violation.Pod,
+				Remediation:  violation.Remediation,
+				ChangeType:   string(change.Type),
+				FullFilePath: change.FullFilePath,
+				OldHash:      change.OldHash,
+				NewHash:      change.NewHash,
+				OldImage:     change.OldImage,
+				NewImage:     change.NewImage,
+				Reasons:      change.ReasonsString(),
+			})
+			if err != nil {
+				return err
+			}
+			if err := deployment.Put(boltKey(id), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		br.logger.Error("err while save data in database ", err)
+		return err
+	}
+	return nil
+}
+
+func (br *BoltRepository) imagesBucket() []byte {
+	return []byte(string(br.table) + "_images")
+}
+
+func (br *BoltRepository) changesBucket() []byte {
+	return []byte(string(br.table) + "_changes")
+}
+
+func boltKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/integrity-sum/internal/core/models"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltRepository(t *testing.T) {
+	repository, err := NewBoltRepository(filepath.Join(t.TempDir(), "test.db"), logrus.New())
+	require.NoError(t, err)
+	defer repository.Close()
+
+	deploymentData := &models.DeploymentData{
+		Image:          "nginx:latest",
+		NamePod:        "app-nginx-hasher-integrity-6b64487565-l8ltd",
+		NameDeployment: "app-nginx-hasher-integrity",
+	}
+
+	isEmpty, err := repository.IsExistDeploymentNameInDB(deploymentData.NameDeployment)
+	require.NoError(t, err)
+	assert.True(t, isEmpty)
+
+	err = repository.SaveHashData([]*api.HashData{
+		{Hash: "1", FileName: "a.txt", FullFilePath: "test/a.txt", MountPath: "test", Algorithm: "SHA256", Metadata: api.Metadata{Type: api.FileTypeRegular, Mode: 0o4755, UID: 1000, Size: 1}},
+		{Hash: "2", FileName: "b.txt", FullFilePath: "other/b.txt", MountPath: "other", Algorithm: "SHA256"},
+		{Hash: "3", FileName: "c.txt", FullFilePath: "test/c.txt", MountPath: "test", Algorithm: "SHA1"},
+		{Hash: "4", FileName: "d.txt", FullFilePath: "test/other/d.txt", MountPath: "test/other", Algorithm: "SHA256"},
+	}, deploymentData)
+	require.NoError(t, err)
+
+	isEmpty, err = repository.IsExistDeploymentNameInDB(deploymentData.NameDeployment)
+	require.NoError(t, err)
+	assert.False(t, isEmpty)
+
+	hashData, err := repository.GetHashData("test", "SHA256", deploymentData)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.HashDataFromDB{{
+		ID:             1,
+		Hash:           "1",
+		FileName:       "a.txt",
+		FullFilePath:   "test/a.txt",
+		MountPath:      "test",
+		Algorithm:      "SHA256",
+		ImageContainer: "nginx:latest",
+		NamePod:        deploymentData.NamePod,
+		NameDeployment: deploymentData.NameDeployment,
+		Metadata:       api.Metadata{Type: api.FileTypeRegular, Mode: 0o4755, UID: 1000, Size: 1},
+	}}, hashData)
+
+	require.NoError(t, repository.DeleteOtherAlgorithms("SHA256", deploymentData))
+	hashData, err = repository.GetHashData("test", "SHA1", deploymentData)
+	require.NoError(t, err)
+	assert.Empty(t, hashData)
+	hashData, err = repository.GetHashData("test", "SHA256", deploymentData)
+	require.NoError(t, err)
+	assert.Len(t, hashData, 1)
+
+	require.NoError(t, repository.DeleteFromTable(deploymentData.NameDeployment))
+	isEmpty, err = repository.IsExistDeploymentNameInDB(deploymentData.NameDeployment)
+	require.NoError(t, err)
+	assert.True(t, isEmpty)
+}
+
+func TestBoltRepositoryRecordWithoutMountPath(t *testing.T) {
+	repository, err := NewBoltRepository(filepath.Join(t.TempDir(), "test.db"), logrus.New())
+	require.NoError(t, err)
+	defer repository.Close()
+
+	deploymentData := &models.DeploymentData{NamePod: "app-6b64487565-l8ltd", NameDeployment: "app"}
+	// Records saved before mount paths were recorded have no mount_path
+	err = repository.db.Update(func(tx *bolt.Tx) error {
+		deployment, err := tx.Bucket(repository.table).CreateBucketIfNotExists([]byte(deploymentData.NameDeployment))
+		if err != nil {
+			return err
+		}
+		for id, fullFilePath := range []string{"/etc/nginx/nginx.conf", "/etc/nginx-other/a.conf", "/usr/bin/nginx"} {
+			value := fmt.Sprintf(`{"id":%d,"file_name":%q,"full_file_path":%q,"hash_sum":"1","algorithm":"SHA256","name_pod":%q,"name_deployment":"app"}`,
+				id+1, filepath.Base(fullFilePath), fullFilePath, deploymentData.NamePod)
+			if err := deployment.Put(boltKey(uint64(id+1)), []byte(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	hashData, err := repository.GetHashData("etc/nginx", "SHA256", deploymentData)
+	require.NoError(t, err)
+	require.Len(t, hashData, 1)
+	assert.Equal(t, "/etc/nginx/nginx.conf", hashData[0].FullFilePath)
+
+	hashData, err = repository.GetHashData("", "SHA256", deploymentData)
+	require.NoError(t, err)
+	assert.Len(t, hashData, 3)
+}
+
+func TestBoltRepositoryProcessRelativePaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	deploymentData := &models.DeploymentData{NamePod: "app-6b64487565-l8ltd", NameDeployment: "app"}
+
+	// A database written before the paths were process-relative
+	db, err := bolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte("hashfiles_schema_version"))
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(boltVersionKey, boltKey(1)); err != nil {
+			return err
+		}
+		table, err := tx.CreateBucketIfNotExists([]byte("hashfiles"))
+		if err != nil {
+			return err
+		}
+		deployment, err := table.CreateBucketIfNotExists([]byte(deploymentData.NameDeployment))
+		if err != nil {
+			return err
+		}
+		for id, record := range []string{
+			`{"id":1,"file_name":"nginx.conf","full_file_path":"/proc/123/root/etc/nginx/nginx.conf","mount_path":"etc/nginx","hash_sum":"1","algorithm":"SHA256","name_pod":%q}`,
+			`{"id":2,"file_name":"mime.types","full_file_path":"../proc/123/root/etc/nginx/mime.types","hash_sum":"2","algorithm":"SHA256","name_pod":%q}`,
+		} {
+			if err := deployment.Put(boltKey(uint64(id+1)), []byte(fmt.Sprintf(record, deploymentData.NamePod))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	repository, err := NewBoltRepository(path, logrus.New())
+	require.NoError(t, err)
+	defer repository.Close()
+
+	hashData, err := repository.GetHashData("etc/nginx", "SHA256", deploymentData)
+	require.NoError(t, err)
+	fullFilePaths := make([]string, 0, len(hashData))
+	for _, data := range hashData {
+		fullFilePaths = append(fullFilePaths, data.FullFilePath)
+	}
+	assert.Equal(t, []string{"/etc/nginx/nginx.conf", "/etc/nginx/mime.types"}, fullFilePaths)
+}
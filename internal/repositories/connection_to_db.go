@@ -4,12 +4,24 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/integrity-sum/internal/core/models"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	defaultMaxOpenConns    = 4
+	defaultMaxIdleConns    = 2
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+)
+
+// ConnectionToDB opens a pool of connections to the database configured by the environment and checks it with a ping.
+// An unavailable database is returned as an error, the caller retries it with its own backoff.
+// The returned pool is long-lived and must be closed by the caller on shutdown
 func ConnectionToDB(logger *logrus.Logger) (*sql.DB, error) {
 	connectionDB := models.ConnectionDB{
 		Dbdriver:   os.Getenv("DB_DRIVER"),
@@ -19,16 +31,47 @@ func ConnectionToDB(logger *logrus.Logger) (*sql.DB, error) {
 		DbHost:     os.Getenv("DB_HOST"),
 		DbName:     os.Getenv("DB_NAME"),
 	}
+	if connectionDB.Dbdriver == "" {
+		connectionDB.Dbdriver = DriverPostgres
+	}
 
 	DBURL := fmt.Sprintf("host=%v port=%s user=%s dbname=%s sslmode=disable password=%s", connectionDB.DbHost, connectionDB.DbPort, connectionDB.DbUser, connectionDB.DbName, connectionDB.DbPassword)
 
 	db, err := sql.Open(connectionDB.Dbdriver, DBURL)
 	if err != nil {
 		logger.Info("Cannot connect to database ", connectionDB.Dbdriver)
-		return db, err
-	} else {
-		logger.Info("Connected to the database ", connectionDB.Dbdriver)
+		return nil, err
 	}
 
+	db.SetMaxOpenConns(envInt("DB_MAX_OPEN_CONNS", defaultMaxOpenConns))
+	db.SetMaxIdleConns(envInt("DB_MAX_IDLE_CONNS", defaultMaxIdleConns))
+	db.SetConnMaxLifetime(envSeconds("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime))
+	db.SetConnMaxIdleTime(envSeconds("DB_CONN_MAX_IDLE_TIME", defaultConnMaxIdleTime))
+
+	if err := db.Ping(); err != nil {
+		logger.Errorf("Cannot connect to database %s: %s", connectionDB.Dbdriver, err)
+		db.Close()
+		return nil, err
+	}
+	logger.Info("Connected to the database ", connectionDB.Dbdriver)
+
 	return db, nil
 }
+
+// envInt returns the integer value of the environment variable or the default value if it is not set or invalid
+func envInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// envSeconds returns the duration set in seconds in the environment variable or the default value
+func envSeconds(key string, defaultValue time.Duration) time.Duration {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return time.Duration(value) * time.Second
+}
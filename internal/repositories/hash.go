@@ -1,8 +1,10 @@
 package repositories
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/integrity-sum/internal/core/models"
 	"github.com/integrity-sum/pkg/api"
@@ -10,40 +12,58 @@ import (
 )
 
 type HashRepository struct {
+	db     *sql.DB
 	logger *logrus.Logger
 }
 
-func NewHashRepository(logger *logrus.Logger) *HashRepository {
+// NewHashRepository creates a new struct HashRepository that uses the shared pool of connections
+func NewHashRepository(db *sql.DB, logger *logrus.Logger) *HashRepository {
 	return &HashRepository{
+		db:     db,
 		logger: logger,
 	}
 }
 
-// SaveHashData iterates through all elements of the slice and triggers the save to database function
-func (hr HashRepository) SaveHashData(allHashData []*api.HashData, deploymentData *models.DeploymentData) error {
-	db, err := ConnectionToDB(hr.logger)
+// Close closes the pool of connections to the database
+func (hr HashRepository) Close() error {
+	return hr.db.Close()
+}
+
+// IsExistDeploymentNameInDB checks if the base is empty
+func (hr HashRepository) IsExistDeploymentNameInDB(deploymentName string) (bool, error) {
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE name_deployment=$1 LIMIT 1;", os.Getenv("TABLE_NAME"))
+	row := hr.db.QueryRow(query, deploymentName)
+	err := row.Scan(&count)
 	if err != nil {
-		hr.logger.Error("failed to connection to database %s", err)
-		return err
+		hr.logger.Error("err while scan row in database ", err)
+		return false, err
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	if count < 1 {
+		return true, nil
+	}
+	return false, nil
+}
+
+// SaveHashData iterates through all elements of the slice and triggers the save to database function
+func (hr HashRepository) SaveHashData(allHashData []*api.HashData, deploymentData *models.DeploymentData) error {
+	tx, err := hr.db.Begin()
 	if err != nil {
 		hr.logger.Error("err while saving data in database ", err)
 		return err
 	}
 	query := fmt.Sprintf(`
-		INSERT INTO %s (file_name,full_file_path,hash_sum,algorithm,name_pod,image_tag,time_of_creation, name_deployment)
-		VALUES($1,$2,$3,$4,$5,$6,$7,$8);`, os.Getenv("TABLE_NAME"))
+		INSERT INTO %s (file_name,full_file_path,hash_sum,algorithm,name_pod,image_tag,time_of_creation,name_deployment,mount_path,container_name,file_type,file_mode,uid,gid,file_size,link_target)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16);`, os.Getenv("TABLE_NAME"))
 
 	for _, hash := range allHashData {
-		_, err = tx.Exec(query, hash.FileName, hash.FullFilePath, hash.Hash, hash.Algorithm, deploymentData.NamePod, deploymentData.Image, deploymentData.Timestamp, deploymentData.NameDeployment)
+		_, err = tx.Exec(query, hash.FileName, hash.FullFilePath, hash.Hash, hash.Algorithm, deploymentData.NamePod, deploymentData.Image, deploymentData.Timestamp, deploymentData.NameDeployment, hash.MountPath, deploymentData.ContainerName,
+			hash.Type, hash.Mode, hash.UID, hash.GID, hash.Size, hash.LinkTarget)
 		if err != nil {
-			err := tx.Rollback()
-			if err != nil {
-				hr.logger.Error("err in Rollback", err)
-				return err
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				hr.logger.Error("err in Rollback", rollbackErr)
+				return rollbackErr
 			}
 			hr.logger.Error("err while save data in database ", err)
 			return err
@@ -53,51 +73,158 @@ func (hr HashRepository) SaveHashData(allHashData []*api.HashData, deploymentDat
 	return tx.Commit()
 }
 
-// GetHashData retrieves data from the database using the path and algorithm
-func (hr HashRepository) GetHashData(dirFiles, algorithm string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error) {
-	db, err := ConnectionToDB(hr.logger)
-	if err != nil {
-		hr.logger.Error("failed to connection to database %s", err)
-		return nil, err
-	}
-	defer db.Close()
-
+// GetHashData retrieves the baseline of the mount path of the container from the database using the algorithm.
+// Rows saved before mount paths were recorded have an empty one and are selected by the path of their file
+func (hr HashRepository) GetHashData(mountPath, algorithm string, deploymentData *models.DeploymentData) ([]*models.HashDataFromDB, error) {
 	var allHashDataFromDB []*models.HashDataFromDB
 
-	query := fmt.Sprintf("SELECT id,file_name,full_file_path,hash_sum,algorithm,image_tag,name_pod,name_deployment FROM %s WHERE full_file_path LIKE $1 and algorithm=$2 and name_pod=$3", os.Getenv("TABLE_NAME"))
+	query := fmt.Sprintf("SELECT id,file_name,full_file_path,mount_path,hash_sum,algorithm,image_tag,name_pod,name_deployment,file_type,file_mode,uid,gid,file_size,link_target FROM %s WHERE (mount_path=$1 OR mount_path='' AND (full_file_path='/'||$1 OR left(full_file_path, length($1)+2)='/'||$1||'/')) and algorithm=$2 and name_pod=$3 and container_name=$4", os.Getenv("TABLE_NAME"))
 
-	rows, err := db.Query(query, "%"+dirFiles+"%", algorithm, deploymentData.NamePod)
+	rows, err := hr.db.Query(query, mountPath, algorithm, deploymentData.NamePod, deploymentData.ContainerName)
 	if err != nil {
 		hr.logger.Error(err)
 		return nil, err
 	}
+	// Closing the rows returns the connection to the pool
+	defer rows.Close()
+
 	for rows.Next() {
 		var hashDataFromDB models.HashDataFromDB
-		err := rows.Scan(&hashDataFromDB.ID, &hashDataFromDB.FileName, &hashDataFromDB.FullFilePath, &hashDataFromDB.Hash, &hashDataFromDB.Algorithm, &hashDataFromDB.ImageContainer, &hashDataFromDB.NamePod, &hashDataFromDB.NameDeployment)
+		err := rows.Scan(&hashDataFromDB.ID, &hashDataFromDB.FileName, &hashDataFromDB.FullFilePath, &hashDataFromDB.MountPath, &hashDataFromDB.Hash, &hashDataFromDB.Algorithm, &hashDataFromDB.ImageContainer, &hashDataFromDB.NamePod, &hashDataFromDB.NameDeployment,
+			&hashDataFromDB.Type, &hashDataFromDB.Mode, &hashDataFromDB.UID, &hashDataFromDB.GID, &hashDataFromDB.Size, &hashDataFromDB.LinkTarget)
 		if err != nil {
 			hr.logger.Error(err)
 			return nil, err
 		}
 		allHashDataFromDB = append(allHashDataFromDB, &hashDataFromDB)
 	}
+	if err := rows.Err(); err != nil {
+		hr.logger.Error(err)
+		return nil, err
+	}
 
 	return allHashDataFromDB, nil
 }
 
 // DeleteFromTable removes data from the table that matches the name of the deployment
 func (hr HashRepository) DeleteFromTable(nameDeployment string) error {
-	db, err := ConnectionToDB(hr.logger)
+	query := fmt.Sprintf("DELETE FROM %s WHERE name_deployment=$1;", os.Getenv("TABLE_NAME"))
+	_, err := hr.db.Exec(query, nameDeployment)
 	if err != nil {
-		hr.logger.Error("failed to connection to database %s", err)
+		hr.logger.Error("err while deleting rows in database", err)
 		return err
 	}
-	defer db.Close()
+	return nil
+}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE name_deployment=$1;", os.Getenv("TABLE_NAME"))
-	_, err = db.Exec(query, nameDeployment)
+// DeleteOtherAlgorithms removes the baseline of the container of the pod saved with algorithms other than the given one
+func (hr HashRepository) DeleteOtherAlgorithms(algorithm string, deploymentData *models.DeploymentData) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE name_pod=$1 and container_name=$2 and algorithm<>$3;", os.Getenv("TABLE_NAME"))
+	_, err := hr.db.Exec(query, deploymentData.NamePod, deploymentData.ContainerName, algorithm)
 	if err != nil {
 		hr.logger.Error("err while deleting rows in database", err)
 		return err
 	}
 	return nil
 }
+
+// SaveImageBaseline replaces the baseline computed from the image with the given digest
+func (hr HashRepository) SaveImageBaseline(imageDigest, imageName string, allHashData []*api.HashData) error {
+	tx, err := hr.db.Begin()
+	if err != nil {
+		hr.logger.Error("err while saving data in database ", err)
+		return err
+	}
+
+	algorithms := make(map[string]struct{})
+	for _, hash := range allHashData {
+		algorithms[hash.Algorithm] = struct{}{}
+	}
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE image_digest=$1 and algorithm=$2 and name_deployment='';", os.Getenv("TABLE_NAME"))
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO %s (file_name,full_file_path,hash_sum,algorithm,name_pod,image_tag,time_of_creation,name_deployment,image_digest,file_type,file_mode,uid,gid,file_size,link_target)
+		VALUES($1,$2,$3,$4,'',$5,$6,'',$7,$8,$9,$10,$11,$12,$13);`, os.Getenv("TABLE_NAME"))
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	for algorithm := range algorithms {
+		if _, err = tx.Exec(deleteQuery, imageDigest, algorithm); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		for _, hash := range allHashData {
+			if _, err = tx.Exec(insertQuery, hash.FileName, hash.FullFilePath, hash.Hash, hash.Algorithm, imageName, timestamp, imageDigest,
+				hash.Type, hash.Mode, hash.UID, hash.GID, hash.Size, hash.LinkTarget); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			hr.logger.Error("err in Rollback", rollbackErr)
+			return rollbackErr
+		}
+		hr.logger.Error("err while save data in database ", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetImageBaseline retrieves the baseline computed from the image with the given digest
+func (hr HashRepository) GetImageBaseline(imageDigest, algorithm string) ([]*models.HashDataFromDB, error) {
+	var allHashDataFromDB []*models.HashDataFromDB
+
+	query := fmt.Sprintf("SELECT id,file_name,full_file_path,hash_sum,algorithm,image_tag,image_digest,file_type,file_mode,uid,gid,file_size,link_target FROM %s WHERE image_digest=$1 and algorithm=$2 and name_deployment=''", os.Getenv("TABLE_NAME"))
+
+	rows, err := hr.db.Query(query, imageDigest, algorithm)
+	if err != nil {
+		hr.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hashDataFromDB models.HashDataFromDB
+		err := rows.Scan(&hashDataFromDB.ID, &hashDataFromDB.FileName, &hashDataFromDB.FullFilePath, &hashDataFromDB.Hash, &hashDataFromDB.Algorithm, &hashDataFromDB.ImageContainer, &hashDataFromDB.ImageDigest,
+			&hashDataFromDB.Type, &hashDataFromDB.Mode, &hashDataFromDB.UID, &hashDataFromDB.GID, &hashDataFromDB.Size, &hashDataFromDB.LinkTarget)
+		if err != nil {
+			hr.logger.Error(err)
+			return nil, err
+		}
+		allHashDataFromDB = append(allHashDataFromDB, &hashDataFromDB)
+	}
+	if err := rows.Err(); err != nil {
+		hr.logger.Error(err)
+		return nil, err
+	}
+
+	return allHashDataFromDB, nil
+}
+
+// SaveViolation saves every difference of the violation as a row of the <table>_changes table
+func (hr HashRepository) SaveViolation(violation *models.Violation) error {
+	tx, err := hr.db.Begin()
+	if err != nil {
+		hr.logger.Error("err while saving data in database ", err)
+		return err
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %s_changes (detected_at,namespace,name_deployment,name_pod,remediation,change_type,full_file_path,old_hash,new_hash,old_image,new_image,reasons)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12);`, os.Getenv("TABLE_NAME"))
+
+	for _, change := range violation.Report.Changes {
+		_, err = tx.Exec(query, violation.DetectedAt, violation.Namespace, violation.Workload, violation.Pod, violation.Remediation,
+			string(change.Type), change.FullFilePath, change.OldHash, change.NewHash, change.OldImage, change.NewImage, change.ReasonsString())
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				hr.logger.Error("err in Rollback", rollbackErr)
+				return rollbackErr
+			}
+			hr.logger.Error("err while save data in database ", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,167 @@
+package repositories
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/integrity-sum/internal/configs"
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+var (
+	tableNameRegexp     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	migrationNameRegexp = regexp.MustCompile(`^(\d+)_[a-z0-9_]+\.sql$`)
+)
+
+// migration is one versioned step of the database schema
+type migration struct {
+	Version int
+	Name    string
+	Query   string
+}
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version of the program
+type ErrSchemaTooNew struct {
+	DBVersion     int
+	BinaryVersion int
+}
+
+func (e *ErrSchemaTooNew) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than version %d supported by this binary, upgrade integrity-sum", e.DBVersion, e.BinaryVersion)
+}
+
+// Is makes the error match configs.ErrInvalidConfig, the binary has to be upgraded
+func (e *ErrSchemaTooNew) Is(target error) bool {
+	return target == configs.ErrInvalidConfig
+}
+
+// ValidateTableName checks that the table name can be safely used in queries
+func ValidateTableName(table string) error {
+	if !tableNameRegexp.MatchString(table) {
+		return fmt.Errorf("%w: invalid table name %q", configs.ErrInvalidConfig, table)
+	}
+	return nil
+}
+
+// loadMigrations reads the embedded migrations and renders them for the given table
+func loadMigrations(table string) ([]migration, error) {
+	const dir = "migrations/postgres"
+	entries, err := postgresMigrations.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		match := migrationNameRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := postgresMigrations.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(entry.Name()).Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+		var query bytes.Buffer
+		if err := tmpl.Execute(&query, struct{ Table string }{Table: table}); err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{
+			Version: version,
+			Name:    strings.TrimSuffix(entry.Name(), ".sql"),
+			Query:   query.String(),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence, expected version %d", m.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate creates the hash table and upgrades it to the latest schema version.
+// The applied version is recorded in the <table>_schema_version table.
+// All steps run in one transaction guarded by an advisory lock, so sidecars starting at the same time do not race
+func Migrate(db *sql.DB, table string, logger *logrus.Logger) (err error) {
+	if err = ValidateTableName(table); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(table)
+	if err != nil {
+		return err
+	}
+	latest := len(migrations)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				logger.Error("err in Rollback", rollbackErr)
+			}
+		}
+	}()
+
+	versionTable := table + "_schema_version"
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", versionTable); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	)`, versionTable)); err != nil {
+		return err
+	}
+
+	var current int
+	if err = tx.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", versionTable)).Scan(&current); err != nil {
+		return err
+	}
+	if current > latest {
+		return &ErrSchemaTooNew{DBVersion: current, BinaryVersion: latest}
+	}
+
+	for _, m := range migrations[current:] {
+		logger.Infof("Applying database migration %s", m.Name)
+		if _, err = tx.Exec(m.Query); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (version, name) VALUES ($1, $2)", versionTable), m.Version, m.Name); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	logger.Infof("Database schema is at version %d", latest)
+	return nil
+}
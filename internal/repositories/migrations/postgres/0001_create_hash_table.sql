@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+    id               SERIAL PRIMARY KEY,
+    file_name        TEXT NOT NULL,
+    full_file_path   TEXT NOT NULL,
+    hash_sum         TEXT NOT NULL,
+    algorithm        TEXT NOT NULL,
+    name_pod         TEXT NOT NULL,
+    image_tag        TEXT NOT NULL,
+    time_of_creation TEXT,
+    name_deployment  TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{.Table}}_name_deployment_idx ON {{.Table}} (name_deployment);
+CREATE INDEX IF NOT EXISTS {{.Table}}_name_pod_idx ON {{.Table}} (name_pod);
+CREATE INDEX IF NOT EXISTS {{.Table}}_full_file_path_idx ON {{.Table}} (full_file_path);
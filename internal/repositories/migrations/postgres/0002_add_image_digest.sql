@@ -0,0 +1,3 @@
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS image_digest TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS {{.Table}}_image_digest_idx ON {{.Table}} (image_digest);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS {{.Table}}_changes (
+    id              SERIAL PRIMARY KEY,
+    detected_at     TIMESTAMPTZ NOT NULL,
+    namespace       TEXT NOT NULL,
+    name_deployment TEXT NOT NULL,
+    name_pod        TEXT NOT NULL,
+    remediation     TEXT NOT NULL,
+    change_type     TEXT NOT NULL,
+    full_file_path  TEXT NOT NULL,
+    old_hash        TEXT NOT NULL,
+    new_hash        TEXT NOT NULL,
+    old_image       TEXT NOT NULL,
+    new_image       TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{.Table}}_changes_name_deployment_idx ON {{.Table}}_changes (name_deployment, detected_at);
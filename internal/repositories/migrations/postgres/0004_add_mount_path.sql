@@ -0,0 +1,3 @@
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS mount_path TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS {{.Table}}_mount_path_idx ON {{.Table}} (name_pod, mount_path);
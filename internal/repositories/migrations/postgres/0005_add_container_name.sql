@@ -0,0 +1,4 @@
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS container_name TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS {{.Table}}_mount_path_idx;
+CREATE INDEX IF NOT EXISTS {{.Table}}_container_mount_path_idx ON {{.Table}} (name_pod, container_name, mount_path);
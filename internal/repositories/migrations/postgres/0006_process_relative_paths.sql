@@ -0,0 +1,2 @@
+UPDATE {{.Table}} SET full_file_path = regexp_replace(full_file_path, '^(\.\./|/)proc/[0-9]+/root', '')
+WHERE full_file_path ~ '^(\.\./|/)proc/[0-9]+/root/';
@@ -0,0 +1,8 @@
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS file_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS file_mode INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS uid INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS gid INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS file_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS link_target TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE {{.Table}}_changes ADD COLUMN IF NOT EXISTS reasons TEXT NOT NULL DEFAULT '';
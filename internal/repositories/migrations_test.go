@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/integrity-sum/internal/configs"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations("hashfiles")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotContains(t, m.Query, "{{")
+	}
+	assert.True(t, strings.Contains(migrations[0].Query, "CREATE TABLE IF NOT EXISTS hashfiles"))
+}
+
+func TestValidateTableName(t *testing.T) {
+	assert.NoError(t, ValidateTableName("hashfiles"))
+	assert.Error(t, ValidateTableName(""))
+	assert.Error(t, ValidateTableName("hashfiles; DROP TABLE users"))
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := loadMigrations("hashfiles")
+	require.NoError(t, err)
+	latest := len(migrations)
+
+	// expectVersion expects the lock, the version table and the query of the current version
+	expectVersion := func(mock sqlmock.Sqlmock, current int) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock(hashtext($1))")).
+			WithArgs("hashfiles_schema_version").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS hashfiles_schema_version").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM hashfiles_schema_version")).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(current))
+	}
+	expectApplied := func(mock sqlmock.Sqlmock, m migration) {
+		mock.ExpectExec(regexp.QuoteMeta(m.Query)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO hashfiles_schema_version (version, name) VALUES ($1, $2)")).
+			WithArgs(m.Version, m.Name).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	testTable := []struct {
+		name          string
+		mockBehavior  func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "empty database",
+			mockBehavior: func(mock sqlmock.Sqlmock) {
+				expectVersion(mock, 0)
+				for _, m := range migrations {
+					expectApplied(mock, m)
+				}
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "applied versions are skipped",
+			mockBehavior: func(mock sqlmock.Sqlmock) {
+				expectVersion(mock, latest-1)
+				expectApplied(mock, migrations[latest-1])
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "up to date",
+			mockBehavior: func(mock sqlmock.Sqlmock) {
+				expectVersion(mock, latest)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "schema newer than the binary",
+			mockBehavior: func(mock sqlmock.Sqlmock) {
+				expectVersion(mock, latest+1)
+				mock.ExpectRollback()
+			},
+			expectedError: configs.ErrInvalidConfig,
+		},
+		{
+			name: "failed migration is rolled back",
+			mockBehavior: func(mock sqlmock.Sqlmock) {
+				expectVersion(mock, latest-2)
+				expectApplied(mock, migrations[latest-2])
+				mock.ExpectExec(regexp.QuoteMeta(migrations[latest-1].Query)).WillReturnError(errSQL)
+				mock.ExpectRollback()
+			},
+			expectedError: errSQL,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			testCase.mockBehavior(mock)
+
+			err = Migrate(db, "hashfiles", logrus.New())
+			if testCase.expectedError != nil {
+				assert.ErrorIs(t, err, testCase.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+var errSQL = errors.New("syntax error")
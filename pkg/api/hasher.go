@@ -2,50 +2,76 @@ package api
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"os"
 	"path/filepath"
 
+	"github.com/integrity-sum/pkg/pathfilter"
 	"github.com/sirupsen/logrus"
 )
 
-// SearchFilePath searches for all files in the given directory
-func SearchFilePath(commonPath string, jobs chan<- string, logger *logrus.Logger) {
+// SearchFilePath sends the files and directories in the given directory selected by the filter to jobs and closes it.
+// Excluded directories are not descended into, the symlink policy decides which file is read for a symlink
+// and special files are never read. The walk stops when the context is done
+func SearchFilePath(ctx context.Context, commonPath string, filter *pathfilter.Filter, symlinks SymlinkPolicy, jobs chan<- Job, logger *logrus.Logger) error {
+	defer close(jobs)
+
 	err := filepath.Walk(commonPath, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			jobs <- path
-		}
 		if err != nil {
-			logger.Error("err while going to path files", err)
-			return err
+			if path == commonPath {
+				return err
+			}
+			// Files may disappear or be unreadable while the directory is walked
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.Error("err while going to path files ", err)
+			}
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// The mount path itself is not recorded, but a single file is hashed
+		if path == commonPath && info.IsDir() {
+			return ctx.Err()
+		}
+		rel := pathfilter.Rel(commonPath, path)
+		if info.IsDir() && filter.SkipDir(rel) {
+			return filepath.SkipDir
+		}
+		// A directory which is not selected is still descended into
+		if !filter.Match(rel) {
+			return ctx.Err()
 		}
 
-		return nil
+		select {
+		case jobs <- symlinks.NewJob(commonPath, path, info):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	})
-	close(jobs)
-
-	if err != nil {
-		logger.Error("not exist directory path", err)
-		return
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		logger.Error("not exist directory path ", err)
 	}
+	return err
 }
 
-// Result launching an infinite loop of receiving and outputting to Stdout the result and signal control
-func Result(ctx context.Context, results chan *HashData, c chan os.Signal) []*HashData {
+// Result collects the results until the channel is closed. If the context is done first,
+// the results are incomplete and the error of the context is returned
+func Result(ctx context.Context, results <-chan *HashData) ([]*HashData, error) {
 	var allHashData []*HashData
 	for {
 		select {
 		case hashData, ok := <-results:
 			if !ok {
-				return allHashData
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return allHashData, nil
 			}
 			allHashData = append(allHashData, hashData)
-		case <-c:
-			fmt.Println("exit program")
-			return nil
 		case <-ctx.Done():
-			fmt.Println("program termination after receiving a signal")
-			return nil
+			return nil, ctx.Err()
 		}
 	}
 }
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/integrity-sum/pkg/pathfilter"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchFilePath(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o750))
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600))
+	}
+
+	t.Run("all files", func(t *testing.T) {
+		jobs := make(chan Job)
+		errc := make(chan error, 1)
+		go func() { errc <- SearchFilePath(context.Background(), dir, nil, SymlinkPolicy{}, jobs, logrus.New()) }()
+
+		var paths []string
+		for job := range jobs {
+			paths = append(paths, job.Path)
+		}
+		sort.Strings(paths)
+		assert.NoError(t, <-errc)
+		assert.Equal(t, []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub"), filepath.Join(dir, "sub/b.txt"), filepath.Join(dir, "sub/c.txt")}, paths)
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		filter, err := pathfilter.New(nil, []string{"sub/", "c.txt"})
+		require.NoError(t, err)
+		jobs := make(chan Job)
+		errc := make(chan error, 1)
+		go func() { errc <- SearchFilePath(context.Background(), dir, filter, SymlinkPolicy{}, jobs, logrus.New()) }()
+
+		var paths []string
+		for job := range jobs {
+			paths = append(paths, job.Path)
+		}
+		assert.NoError(t, <-errc)
+		assert.Equal(t, []string{filepath.Join(dir, "a.txt")}, paths)
+	})
+
+	t.Run("cancelled while nobody reads", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		jobs := make(chan Job)
+		errc := make(chan error, 1)
+		go func() { errc <- SearchFilePath(ctx, dir, nil, SymlinkPolicy{}, jobs, logrus.New()) }()
+
+		cancel()
+		assert.ErrorIs(t, <-errc, context.Canceled)
+		_, ok := <-jobs
+		assert.False(t, ok)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		jobs := make(chan Job)
+		err := SearchFilePath(context.Background(), filepath.Join(dir, "missing"), nil, SymlinkPolicy{}, jobs, logrus.New())
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestResult(t *testing.T) {
+	results := make(chan *HashData, 2)
+	results <- &HashData{FileName: "a.txt"}
+	close(results)
+	allHashData, err := Result(context.Background(), results)
+	require.NoError(t, err)
+	assert.Len(t, allHashData, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Result(ctx, make(chan *HashData))
+	assert.ErrorIs(t, err, context.Canceled)
+}
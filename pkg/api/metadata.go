@@ -0,0 +1,66 @@
+package api
+
+import (
+	"os"
+	"syscall"
+)
+
+// Unix permission bits above the rwx bits, os.FileMode keeps them elsewhere
+const (
+	modeSetuid = 0o4000
+	modeSetgid = 0o2000
+	modeSticky = 0o1000
+)
+
+// ReadMetadata returns the metadata of the file without following a symlink
+func ReadMetadata(path string) (Metadata, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	metadata := Metadata{Type: fileType(info.Mode()), Mode: UnixMode(info.Mode())}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		metadata.UID = int(stat.Uid)
+		metadata.GID = int(stat.Gid)
+	}
+	switch metadata.Type {
+	case FileTypeRegular:
+		metadata.Size = info.Size()
+	case FileTypeSymlink:
+		if metadata.LinkTarget, err = os.Readlink(path); err != nil {
+			return Metadata{}, err
+		}
+		metadata.Size = int64(len(metadata.LinkTarget))
+		// The permissions of a symlink are not used and differ between filesystems
+		metadata.Mode = 0
+	}
+	return metadata, nil
+}
+
+// UnixMode converts the permissions of the file mode to the layout of chmod
+func UnixMode(mode os.FileMode) uint32 {
+	result := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		result |= modeSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		result |= modeSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		result |= modeSticky
+	}
+	return result
+}
+
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return FileTypeRegular
+	case mode.IsDir():
+		return FileTypeDir
+	case mode&os.ModeSymlink != 0:
+		return FileTypeSymlink
+	default:
+		return FileTypeOther
+	}
+}
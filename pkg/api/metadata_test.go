@@ -0,0 +1,41 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "nginx")
+	require.NoError(t, os.WriteFile(file, []byte("binary"), 0o600))
+	require.NoError(t, os.Chmod(file, 0o755|os.ModeSetuid))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0o750))
+	require.NoError(t, os.Symlink("nginx", filepath.Join(dir, "link")))
+
+	testTable := []struct {
+		name     string
+		path     string
+		expected Metadata
+	}{
+		{name: "setuid file", path: file, expected: Metadata{Type: FileTypeRegular, Mode: 0o4755, Size: 6}},
+		{name: "directory", path: filepath.Join(dir, "conf.d"), expected: Metadata{Type: FileTypeDir, Mode: 0o750}},
+		{name: "symlink is not followed", path: filepath.Join(dir, "link"), expected: Metadata{Type: FileTypeSymlink, Size: 5, LinkTarget: "nginx"}},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			metadata, err := ReadMetadata(testCase.path)
+			require.NoError(t, err)
+			testCase.expected.UID = os.Getuid()
+			testCase.expected.GID = os.Getgid()
+			assert.Equal(t, testCase.expected, metadata)
+		})
+	}
+
+	_, err := ReadMetadata(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
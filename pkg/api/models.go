@@ -1,8 +1,31 @@
 package api
 
+// Types of the files recorded in Metadata
+const (
+	FileTypeRegular = "file"
+	FileTypeDir     = "dir"
+	FileTypeSymlink = "symlink"
+	FileTypeOther   = "other"
+)
+
+// Metadata describes a file apart from its content. An empty Type marks data recorded
+// before the metadata was, it is compared by content only
+type Metadata struct {
+	Type string
+	// Mode holds the permission bits with setuid, setgid and sticky in the layout of chmod, like 04755
+	Mode uint32
+	UID  int
+	GID  int
+	// Size is the size of a regular file or the length of the target of a symlink, 0 for other types
+	Size       int64
+	LinkTarget string
+}
+
 type HashData struct {
 	Hash         string
 	FileName     string
 	FullFilePath string
+	MountPath    string
 	Algorithm    string
+	Metadata
 }
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// How a symlink found by SearchFilePath is hashed, configured with SYMLINKS
+const (
+	// SymlinksRecord records the target of a symlink without reading the file it points to
+	SymlinksRecord = "record"
+	// SymlinksWithin also hashes the file a symlink points to when it is under the walked directory
+	SymlinksWithin = "within"
+	// SymlinksAnywhere also hashes the file a symlink points to anywhere under the root of the filesystem
+	SymlinksAnywhere = "anywhere"
+	// DefaultSymlinks keeps following the links of ConfigMap and Secret volumes, which stay within the mount path
+	DefaultSymlinks = SymlinksWithin
+)
+
+const maxSymlinkHops = 40
+
+var (
+	// ErrUnknownSymlinks is returned for a symlink policy which is not supported
+	ErrUnknownSymlinks = errors.New("unknown symlink policy")
+	// ErrSymlinkLoop is returned when a path has too many levels of symlinks
+	ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+)
+
+// ParseSymlinks returns the symlink policy with the given name, the default one if the name is empty
+func ParseSymlinks(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return DefaultSymlinks, nil
+	case SymlinksRecord, SymlinksWithin, SymlinksAnywhere:
+		return name, nil
+	default:
+		return "", fmt.Errorf("%w %q, use %s, %s or %s", ErrUnknownSymlinks, name, SymlinksRecord, SymlinksWithin, SymlinksAnywhere)
+	}
+}
+
+// SymlinkPolicy decides which file is read for every path found by SearchFilePath
+type SymlinkPolicy struct {
+	// Mode is SymlinksRecord, SymlinksWithin or SymlinksAnywhere, SymlinksWithin if empty
+	Mode string
+	// Root is the root of the filesystem the files belong to, like /proc/<pid>/root. Symlinks are resolved
+	// the way the process sees them and never lead out of it. The root of the sidecar is used if empty
+	Root string
+}
+
+// Job is a file found by SearchFilePath
+type Job struct {
+	// Path is the file recorded
+	Path string
+	// Content is the regular file the content is read from, empty if the file is recorded by its metadata only
+	Content string
+	// Root is the root of the filesystem Content is opened in, a component of Content replaced by a symlink
+	// after the file was found is resolved under it. The root of the sidecar is used if empty
+	Root string
+}
+
+// NewJob returns the job of the file found under dir, info is the result of Lstat of the file.
+// Directories and special files are never read, a symlink is read only if the policy allows its target
+func (p SymlinkPolicy) NewJob(dir, name string, info os.FileInfo) Job {
+	job := Job{Path: name, Root: p.Root}
+	switch {
+	case info.Mode().IsRegular():
+		job.Content = name
+	case info.Mode()&os.ModeSymlink != 0:
+		job.Content = p.target(dir, name)
+	}
+	return job
+}
+
+// target returns the regular file the symlink points to if the policy allows to read it, otherwise an empty string
+func (p SymlinkPolicy) target(dir, name string) string {
+	root := p.root()
+	scope := dir
+	switch p.Mode {
+	case SymlinksRecord:
+		return ""
+	case SymlinksAnywhere:
+		scope = root
+	}
+	// A relative path given on the command line is resolved from the working directory
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return ""
+	}
+	if scope, err = filepath.Abs(scope); err != nil {
+		return ""
+	}
+	target, err := ResolveIn(root, name)
+	if err != nil || !isUnder(target, scope) {
+		return ""
+	}
+	info, err := os.Lstat(target)
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return target
+}
+
+func (p SymlinkPolicy) root() string {
+	if p.Root == "" {
+		return "/"
+	}
+	return filepath.Clean(p.Root)
+}
+
+// ResolveIn resolves the symlinks in the path of the file under root as if root was the root of the filesystem:
+// an absolute target starts at root and ".." never leads out of it
+func ResolveIn(root, name string) (string, error) {
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is not under %s", name, root)
+	}
+
+	resolved := "/"
+	remaining := filepath.ToSlash(rel)
+	for hops := 0; remaining != ""; {
+		var part string
+		part, remaining = splitFirst(remaining)
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", fmt.Errorf("%s: %w", name, ErrSymlinkLoop)
+		}
+		link, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			resolved = "/"
+		}
+		// The rest of the path is joined without cleaning, ".." must be applied after the link is resolved
+		remaining = link + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+func splitFirst(name string) (string, string) {
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// isUnder reports whether the path is dir itself or inside it
+func isUnder(name, dir string) bool {
+	return dir == "/" || name == dir || strings.HasPrefix(name, dir+"/")
+}
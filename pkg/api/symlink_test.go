@@ -0,0 +1,99 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymlinkPolicy(t *testing.T) {
+	// root is the filesystem of the process, the mount path is /etc/app
+	root := t.TempDir()
+	dir := filepath.Join(root, "etc/app")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "..data"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "..data/config.yaml"), []byte("config"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc/passwd"), []byte("root"), 0o600))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(dir, "fifo"), 0o600))
+	links := map[string]string{
+		"config.yaml": "..data/config.yaml",
+		"passwd":      "../passwd",
+		"absolute":    "/etc/passwd",
+		"escape":      "../../../../../etc/passwd",
+		"zero":        "/dev/zero",
+		"pipe":        "fifo",
+		"data":        "..data",
+		"loop":        "loop",
+	}
+	for name, target := range links {
+		require.NoError(t, os.Symlink(target, filepath.Join(dir, name)))
+	}
+
+	content := func(policy SymlinkPolicy, name string) string {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		job := policy.NewJob(dir, filepath.Join(dir, name), info)
+		assert.Equal(t, filepath.Join(dir, name), job.Path)
+		return job.Content
+	}
+
+	testTable := []struct {
+		name     string
+		mode     string
+		expected map[string]string
+	}{
+		{
+			name: "record",
+			mode: SymlinksRecord,
+			expected: map[string]string{
+				"config.yaml": "", "passwd": "", "absolute": "",
+			},
+		},
+		{
+			name: "within the mount path",
+			mode: SymlinksWithin,
+			expected: map[string]string{
+				"config.yaml": filepath.Join(dir, "..data/config.yaml"), "passwd": "", "absolute": "",
+			},
+		},
+		{
+			name: "anywhere under the root",
+			mode: SymlinksAnywhere,
+			expected: map[string]string{
+				"config.yaml": filepath.Join(dir, "..data/config.yaml"),
+				"passwd":      filepath.Join(root, "etc/passwd"),
+				"absolute":    filepath.Join(root, "etc/passwd"),
+				"escape":      filepath.Join(root, "etc/passwd"),
+			},
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			policy := SymlinkPolicy{Mode: testCase.mode, Root: root}
+			assert.Equal(t, filepath.Join(dir, "..data/config.yaml"), content(policy, "..data/config.yaml"))
+			// Special files, directories, links out of the root and loops are never read
+			for _, name := range []string{"fifo", "zero", "pipe", "data", "loop", "..data"} {
+				assert.Empty(t, content(policy, name), name)
+			}
+			for name, expected := range testCase.expected {
+				assert.Equal(t, expected, content(policy, name), name)
+			}
+		})
+	}
+}
+
+func TestParseSymlinks(t *testing.T) {
+	policy, err := ParseSymlinks("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSymlinks, policy)
+
+	policy, err = ParseSymlinks(" Anywhere")
+	require.NoError(t, err)
+	assert.Equal(t, SymlinksAnywhere, policy)
+
+	_, err = ParseSymlinks("follow")
+	assert.ErrorIs(t, err, ErrUnknownSymlinks)
+}
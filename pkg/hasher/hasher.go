@@ -5,26 +5,65 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"errors"
+	"fmt"
 	"hash"
+	"sort"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
-// NewHashSum takes a hashing algorithm as input and returns a hash sum with other data or an error
-func NewHashSum(alg string) hash.Hash {
-
-	switch alg {
-	case "MD5":
-		return md5.New()
-	case "SHA1":
-		return sha1.New()
-	case "SHA224":
-		return sha256.New224()
-	case "SHA384":
-		return sha512.New384()
-	case "SHA512":
-		return sha512.New()
-	case "SHA256":
-		fallthrough
-	default:
-		return sha256.New()
+// DefaultAlgorithm is used when no algorithm is configured
+const DefaultAlgorithm = "SHA256"
+
+// ErrUnknownAlgorithm is returned for an algorithm which is not in the registry
+var ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+
+// algorithms is the registry of the supported algorithms by their upper-case names
+var algorithms = map[string]func() hash.Hash{
+	"MD5":         md5.New,
+	"SHA1":        sha1.New,
+	"SHA224":      sha256.New224,
+	"SHA256":      sha256.New,
+	"SHA384":      sha512.New384,
+	"SHA512":      sha512.New,
+	"SHA3-256":    sha3.New256,
+	"SHA3-512":    sha3.New512,
+	"BLAKE2B-256": mustBlake2b(blake2b.New256),
+	"BLAKE2B-512": mustBlake2b(blake2b.New512),
+	"BLAKE3":      func() hash.Hash { return blake3.New() },
+}
+
+// NewHashSum takes a hashing algorithm as input and returns a new hash or an error if the algorithm is unknown.
+// The name is case-insensitive
+func NewHashSum(alg string) (hash.Hash, error) {
+	newHash, ok := algorithms[strings.ToUpper(alg)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, supported: %s", ErrUnknownAlgorithm, alg, strings.Join(Algorithms(), ", "))
+	}
+	return newHash(), nil
+}
+
+// Algorithms returns the names of the supported algorithms in alphabetical order
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mustBlake2b adapts an unkeyed BLAKE2b constructor, it fails only for a key longer than 64 bytes
+func mustBlake2b(newHash func(key []byte) (hash.Hash, error)) func() hash.Hash {
+	return func() hash.Hash {
+		h, err := newHash(nil)
+		if err != nil {
+			panic(err)
+		}
+		return h
 	}
 }
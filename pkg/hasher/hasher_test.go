@@ -3,41 +3,47 @@ package hasher
 import (
 	"encoding/hex"
 	"io"
-	"log"
-	"os"
 	"testing"
-)
 
-const fp = "../../.editorconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
+// defaultValues are the hash sums of "abc" from the test vectors of the algorithms
 var defaultValues = map[string]string{
-	"MD5":    "f670b69b3d123fa53e3e1848a0b6bf6b",
-	"SHA1":   "da39a3ee5e6b4b0d3255bfef95601890afd80709",
-	"SHA224": "d14a028c2a3a2bc9476102bb288234c415a2b01f828ea62ac5b3e42f",
-	"SHA384": "38b060a751ac96384cd9327eb1b1e36a21fdb71114be07434c0cc7bf63f6e1da274edebfe76f65fbd51ad2f14898b95b",
-	"SHA512": "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
-	"SHA256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	"MD5":         "900150983cd24fb0d6963f7d28e17f72",
+	"SHA1":        "a9993e364706816aba3e25717850c26c9cd0d89d",
+	"SHA224":      "23097d223405d8228642a477bda255b32aadbce4bda0b3f7e36c9da7",
+	"SHA256":      "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	"SHA384":      "cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed8086072ba1e7cc2358baeca134c825a7",
+	"SHA512":      "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+	"SHA3-256":    "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+	"SHA3-512":    "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0",
+	"BLAKE2B-256": "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+	"BLAKE2B-512": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	"BLAKE3":      "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
 }
 
 func TestNewHashSum(t *testing.T) {
-	hashAlgos := []string{"MD5", "SHA1", "SHA224", "SHA384", "SHA512", "SHA256"}
-	file, err := os.OpenFile(fp, os.O_RDONLY, 0)
-	if err != nil {
-		log.Printf("Error reading file[%s]: %s", fp, err)
-		t.Fail()
-		return
+	assert.Len(t, Algorithms(), len(defaultValues))
+	for _, alg := range Algorithms() {
+		t.Run(alg, func(t *testing.T) {
+			hashSummator, err := NewHashSum(alg)
+			require.NoError(t, err)
+			_, err = io.WriteString(hashSummator, "abc")
+			require.NoError(t, err)
+			assert.Equal(t, defaultValues[alg], hex.EncodeToString(hashSummator.Sum(nil)))
+		})
 	}
-	for _, v := range hashAlgos {
-		hashSummator := NewHashSum(v)
-		_, err = io.Copy(hashSummator, file)
-		if err != nil {
-			log.Printf("Error reading file[%s] with algorithm[%s]: %s", fp, v, err)
-		}
-		res := hex.EncodeToString(hashSummator.Sum(nil))
-		if res != defaultValues[v] {
-			log.Printf("New hash not corresponding to default value. %s(new hash) != %s(default value)", res, defaultValues[v])
-			t.Fail()
-			return
+
+	t.Run("case-insensitive", func(t *testing.T) {
+		_, err := NewHashSum("blake2b-256")
+		assert.NoError(t, err)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		for _, alg := range []string{"", "SHA-256", "SHA3"} {
+			_, err := NewHashSum(alg)
+			assert.ErrorIs(t, err, ErrUnknownAlgorithm, alg)
 		}
-	}
+	})
 }
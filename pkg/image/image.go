@@ -0,0 +1,366 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/hasher"
+	"github.com/integrity-sum/pkg/pathfilter"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+	maxSymlinkHops = 40
+	// implicitDirMode is the mode of the directories created for the paths of a layer without an entry of their own
+	implicitDirMode = 0o755
+)
+
+type entryKind int
+
+const (
+	kindFile entryKind = iota
+	kindDir
+	kindSymlink
+	kindHardlink
+	kindOther
+)
+
+// entry is the topmost version of a path in the layered filesystem
+type entry struct {
+	kind     entryKind
+	linkname string
+	layer    int
+	metadata api.Metadata
+}
+
+// Result is the baseline of an image
+type Result struct {
+	// Digests identify the image, the baseline is saved under each of them so the digest reported by the runtime finds it
+	Digests  []string
+	HashData []*api.HashData
+}
+
+// Baseline computes the hash sums of the files under the mount paths in the final filesystem of the image
+// stored as an OCI image layout directory or a `docker save` tarball. Layers are applied from the lowest
+// to the topmost honouring whiteouts, symlinks are resolved inside the image and followed according to the symlinks
+// policy the same way the sidecar follows them under /proc/<pid>/root. Only the files selected by the filter are hashed.
+// Directories, special files and symlinks which are not followed are recorded by their metadata as the sidecar does,
+// the metadata is taken from the tar headers
+func Baseline(imagePath string, mountPaths []string, algorithm, platform, symlinks string, filter *pathfilter.Filter) (*Result, error) {
+	// An unknown algorithm is reported before the layers are read
+	if _, err := hasher.NewHashSum(algorithm); err != nil {
+		return nil, err
+	}
+	l, cleanup, err := openLayout(imagePath, platform)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	entries := make(map[string]*entry)
+	for i, layer := range l.layers {
+		if err := applyLayer(entries, layer, i); err != nil {
+			return nil, fmt.Errorf("layer %s: %w", layer, err)
+		}
+	}
+
+	// Map every file under the mount paths to the file holding its content
+	targets := make(map[string]string)
+	var unread []string
+	for _, mountPath := range mountPaths {
+		root := path.Clean("/" + strings.TrimSpace(mountPath))
+		for name, e := range entries {
+			if !isUnder(name, root) || !filter.Match(pathfilter.Rel(root, name)) {
+				continue
+			}
+			switch e.kind {
+			case kindDir:
+				// The mount path itself is not recorded
+				if name != root {
+					unread = append(unread, name)
+				}
+				continue
+			case kindOther:
+				unread = append(unread, name)
+				continue
+			}
+			target, ok := resolve(entries, name)
+			if ok && e.kind == kindSymlink && !follows(symlinks, root, target) {
+				ok = false
+			}
+			if !ok {
+				if e.kind == kindSymlink {
+					unread = append(unread, name)
+				}
+				continue
+			}
+			targets[name] = target
+		}
+	}
+
+	wanted := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		wanted[target] = struct{}{}
+	}
+	sums := make(map[string]string, len(wanted))
+	for i, layer := range l.layers {
+		if err := hashLayer(entries, wanted, sums, layer, i, algorithm); err != nil {
+			return nil, fmt.Errorf("layer %s: %w", layer, err)
+		}
+	}
+
+	result := &Result{Digests: l.digests}
+	for name, target := range targets {
+		sum, ok := sums[target]
+		if !ok {
+			continue
+		}
+		metadata := entries[name].metadata
+		// A hard link shares the inode, so the mode, the owner and the size of the file it links to
+		if entries[name].kind == kindHardlink {
+			metadata = entries[target].metadata
+		}
+		result.HashData = append(result.HashData, &api.HashData{
+			Hash:         sum,
+			FileName:     path.Base(name),
+			FullFilePath: name,
+			Algorithm:    algorithm,
+			Metadata:     metadata,
+		})
+	}
+	for _, name := range unread {
+		result.HashData = append(result.HashData, &api.HashData{
+			FileName:     path.Base(name),
+			FullFilePath: name,
+			Algorithm:    algorithm,
+			Metadata:     entries[name].metadata,
+		})
+	}
+	sort.Slice(result.HashData, func(i, j int) bool {
+		return result.HashData[i].FullFilePath < result.HashData[j].FullFilePath
+	})
+	return result, nil
+}
+
+// applyLayer adds the entries of the layer on top of the entries of the lower layers
+func applyLayer(entries map[string]*entry, layer string, index int) error {
+	return readLayer(layer, func(name string, hdr *tar.Header, _ io.Reader) error {
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+
+		switch {
+		case base == whiteoutOpaque:
+			for existing, e := range entries {
+				if e.layer < index && isUnder(existing, dir) && existing != dir {
+					delete(entries, existing)
+				}
+			}
+			return nil
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removeTree(entries, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+			return nil
+		}
+
+		e := &entry{layer: index, metadata: headerMetadata(hdr)}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			e.kind = kindFile
+		case tar.TypeDir:
+			e.kind = kindDir
+		case tar.TypeSymlink:
+			e.kind = kindSymlink
+			e.linkname = hdr.Linkname
+		case tar.TypeLink:
+			e.kind = kindHardlink
+			e.linkname = path.Clean("/" + hdr.Linkname)
+		default:
+			e.kind = kindOther
+		}
+
+		if existing, ok := entries[name]; ok && existing.kind == kindDir && e.kind != kindDir {
+			removeTree(entries, name)
+		}
+		entries[name] = e
+		addParents(entries, dir, index)
+		return nil
+	})
+}
+
+// addParents adds the directories of the path which have no entry of their own in the tarball.
+// The runtime creates them when the layer is extracted with the mode 0755 owned by root,
+// replacing a file of a lower layer, while a symlink of a lower layer is kept
+func addParents(entries map[string]*entry, dir string, index int) {
+	for ; dir != "/"; dir = path.Dir(dir) {
+		if existing, ok := entries[dir]; ok && (existing.kind == kindDir || existing.kind == kindSymlink) {
+			return
+		}
+		entries[dir] = &entry{kind: kindDir, layer: index, metadata: api.Metadata{Type: api.FileTypeDir, Mode: implicitDirMode}}
+	}
+}
+
+// hashLayer computes the hash sums of the wanted files whose topmost version is in this layer
+func hashLayer(entries map[string]*entry, wanted map[string]struct{}, sums map[string]string, layer string, index int, algorithm string) error {
+	return readLayer(layer, func(name string, hdr *tar.Header, content io.Reader) error {
+		if _, ok := wanted[name]; !ok || entries[name].layer != index || entries[name].kind != kindFile {
+			return nil
+		}
+		h, err := hasher.NewHashSum(algorithm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, content); err != nil {
+			return err
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+}
+
+// headerMetadata returns the metadata of the file as api.ReadMetadata reads it once the layer is extracted
+func headerMetadata(hdr *tar.Header) api.Metadata {
+	metadata := api.Metadata{Mode: uint32(hdr.Mode) & 0o7777, UID: hdr.Uid, GID: hdr.Gid}
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		metadata.Type = api.FileTypeRegular
+		metadata.Size = hdr.Size
+	case tar.TypeLink:
+		// The metadata of a hard link is the one of the file it links to
+		metadata.Type = api.FileTypeRegular
+	case tar.TypeDir:
+		metadata.Type = api.FileTypeDir
+	case tar.TypeSymlink:
+		metadata.Type = api.FileTypeSymlink
+		metadata.Mode = 0
+		metadata.LinkTarget = hdr.Linkname
+		metadata.Size = int64(len(hdr.Linkname))
+	default:
+		metadata.Type = api.FileTypeOther
+	}
+	return metadata
+}
+
+// readLayer calls fn for every entry of a plain or gzip compressed layer tarball
+func readLayer(layer string, fn func(name string, hdr *tar.Header, content io.Reader) error) error {
+	file, err := os.Open(layer)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	magic, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	var r io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("zstd compressed layers are not supported")
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(path.Clean("/"+hdr.Name), hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// resolve follows symlinks and hard links to the regular file holding the content of the path
+func resolve(entries map[string]*entry, name string) (string, bool) {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		e, ok := entries[name]
+		if !ok {
+			resolved, changed := resolveParents(entries, name)
+			if !changed {
+				return "", false
+			}
+			name = resolved
+			continue
+		}
+
+		switch e.kind {
+		case kindFile:
+			return name, true
+		case kindSymlink:
+			name = linkTarget(name, e.linkname)
+		case kindHardlink:
+			name = e.linkname
+		default:
+			// Directories and special files are not hashed by the sidecar either
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// follows reports whether the symlinks policy allows to read the target of a symlink under the mount path root
+func follows(symlinks, root, target string) bool {
+	switch symlinks {
+	case api.SymlinksRecord:
+		return false
+	case api.SymlinksAnywhere:
+		return true
+	default:
+		return isUnder(target, root)
+	}
+}
+
+// resolveParents replaces the first symlinked directory in the path with its target
+func resolveParents(entries map[string]*entry, name string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		dir := "/" + strings.Join(parts[:i], "/")
+		if e, ok := entries[dir]; ok && e.kind == kindSymlink {
+			return path.Join(linkTarget(dir, e.linkname), strings.Join(parts[i:], "/")), true
+		}
+	}
+	return name, false
+}
+
+func linkTarget(name, linkname string) string {
+	if path.IsAbs(linkname) {
+		return path.Clean(linkname)
+	}
+	return path.Join(path.Dir(name), linkname)
+}
+
+func removeTree(entries map[string]*entry, name string) {
+	for existing := range entries {
+		if isUnder(existing, name) {
+			delete(entries, existing)
+		}
+	}
+}
+
+// isUnder reports whether the path is root itself or inside it
+func isUnder(name, root string) bool {
+	return root == "/" || name == root || strings.HasPrefix(name, root+"/")
+}
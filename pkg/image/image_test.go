@@ -0,0 +1,226 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/integrity-sum/internal/repositories"
+	"github.com/integrity-sum/pkg/api"
+	"github.com/integrity-sum/pkg/hasher"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+	mode     int64
+	uid      int
+}
+
+func writeTar(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0o644
+		}
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: mode, Uid: e.uid, Size: int64(len(e.content))}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func sum(content string) string {
+	h, _ := hasher.NewHashSum("SHA256")
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestBaseline(t *testing.T) {
+	lower := writeTar(t, []tarEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/nginx/", typeflag: tar.TypeDir},
+		{name: "etc/nginx/nginx.conf", typeflag: tar.TypeReg, content: "old config"},
+		{name: "etc/nginx/deleted.conf", typeflag: tar.TypeReg, content: "deleted"},
+		{name: "etc/nginx/conf.d/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "etc/nginx/conf.d/default.conf", typeflag: tar.TypeReg, content: "hidden by opaque"},
+		{name: "etc/mime.types", typeflag: tar.TypeReg, content: "types"},
+		{name: "etc/passwd", typeflag: tar.TypeReg, content: "outside of the mount path"},
+	})
+	upper := writeTar(t, []tarEntry{
+		{name: "etc/nginx/nginx.conf", typeflag: tar.TypeReg, content: "new config"},
+		{name: "etc/nginx/.wh.deleted.conf", typeflag: tar.TypeReg},
+		{name: "etc/nginx/conf.d/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: "etc/nginx/conf.d/app.conf", typeflag: tar.TypeReg, content: "app", mode: 0o4755, uid: 101},
+		{name: "etc/nginx/mime.types", typeflag: tar.TypeSymlink, linkname: "../mime.types"},
+		{name: "etc/nginx/hardlink.conf", typeflag: tar.TypeLink, linkname: "etc/nginx/conf.d/app.conf"},
+		{name: "etc/nginx/dangling", typeflag: tar.TypeSymlink, linkname: "/nowhere"},
+	})
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lower"), 0o750))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "upper"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lower", "layer.tar"), lower, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "upper", "layer.tar"), upper, 0o600))
+	manifest, err := json.Marshal([]dockerManifest{{Config: "0123abcd.json", Layers: []string{"lower/layer.tar", "upper/layer.tar"}}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), manifest, 0o600))
+
+	result, err := Baseline(dir, []string{"etc/nginx"}, "SHA256", "linux/amd64", api.SymlinksAnywhere, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"sha256:0123abcd"}, result.Digests)
+	assert.Equal(t, []*api.HashData{
+		{FileName: "conf.d", FullFilePath: "/etc/nginx/conf.d", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeDir, Mode: 0o755}},
+		{Hash: sum("app"), FileName: "app.conf", FullFilePath: "/etc/nginx/conf.d/app.conf", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeRegular, Mode: 0o4755, UID: 101, Size: 3}},
+		{FileName: "dangling", FullFilePath: "/etc/nginx/dangling", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeSymlink, Size: 8, LinkTarget: "/nowhere"}},
+		{Hash: sum("app"), FileName: "hardlink.conf", FullFilePath: "/etc/nginx/hardlink.conf", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeRegular, Mode: 0o4755, UID: 101, Size: 3}},
+		{Hash: sum("types"), FileName: "mime.types", FullFilePath: "/etc/nginx/mime.types", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeSymlink, Size: 13, LinkTarget: "../mime.types"}},
+		{Hash: sum("new config"), FileName: "nginx.conf", FullFilePath: "/etc/nginx/nginx.conf", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeRegular, Mode: 0o644, Size: 10}},
+	}, result.HashData)
+
+	// The link to a file outside of the mount path is only recorded unless links are followed anywhere
+	result, err = Baseline(dir, []string{"etc/nginx"}, "SHA256", "linux/amd64", api.SymlinksWithin, nil)
+	require.NoError(t, err)
+	found := false
+	for _, data := range result.HashData {
+		if data.FileName == "mime.types" {
+			found = true
+			assert.Empty(t, data.Hash)
+			assert.Equal(t, "../mime.types", data.LinkTarget)
+		}
+	}
+	assert.True(t, found, "mime.types is recorded")
+}
+
+func TestBaselineImplicitDirectories(t *testing.T) {
+	// Layers built by some tools have no entries for the directories
+	layer := writeTar(t, []tarEntry{
+		{name: "etc/nginx/nginx.conf", typeflag: tar.TypeReg, content: "config"},
+		{name: "etc/nginx/conf.d/app.conf", typeflag: tar.TypeReg, content: "app"},
+	})
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "layer.tar"), layer, 0o600))
+	manifest, err := json.Marshal([]dockerManifest{{Config: "0123abcd.json", Layers: []string{"layer.tar"}}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), manifest, 0o600))
+
+	result, err := Baseline(dir, []string{"etc/nginx"}, "SHA256", "linux/amd64", api.SymlinksWithin, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*api.HashData{
+		{FileName: "conf.d", FullFilePath: "/etc/nginx/conf.d", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeDir, Mode: 0o755}},
+		{Hash: sum("app"), FileName: "app.conf", FullFilePath: "/etc/nginx/conf.d/app.conf", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeRegular, Mode: 0o644, Size: 3}},
+		{Hash: sum("config"), FileName: "nginx.conf", FullFilePath: "/etc/nginx/nginx.conf", Algorithm: "SHA256",
+			Metadata: api.Metadata{Type: api.FileTypeRegular, Mode: 0o644, Size: 6}},
+	}, result.HashData)
+}
+
+// writeBlob stores the content in the blobs of an OCI layout and returns its digest
+func writeBlob(t *testing.T, dir string, content []byte) string {
+	digest := "sha256:" + sum(string(content))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o750))
+	require.NoError(t, os.WriteFile(blobPath(dir, digest), content, 0o600))
+	return digest
+}
+
+func TestBaselineDigests(t *testing.T) {
+	layer := writeTar(t, []tarEntry{{name: "etc/nginx/nginx.conf", typeflag: tar.TypeReg, content: "config"}})
+	const repoDigest = "sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac"
+
+	testTable := []struct {
+		name string
+		// imageID is the format of the image ID reported for the first digest of the image
+		imageID string
+		layout  func(t *testing.T, dir string) []string
+	}{
+		{
+			name:    "docker save archive with repository digests",
+			imageID: "docker.io/library/nginx@%s",
+			layout: func(t *testing.T, dir string) []string {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "layer.tar"), layer, 0o600))
+				manifest, err := json.Marshal([]dockerManifest{{Config: "0123abcd.json", RepoDigests: []string{"nginx@" + repoDigest}, Layers: []string{"layer.tar"}}})
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), manifest, 0o600))
+				return []string{repoDigest, "sha256:0123abcd"}
+			},
+		},
+		{
+			name:    "OCI layout of a multi-platform image",
+			imageID: "docker-pullable://nginx@%s",
+			layout: func(t *testing.T, dir string) []string {
+				layerDigest := writeBlob(t, dir, layer)
+				configDigest := writeBlob(t, dir, []byte("{}"))
+				manifest, err := json.Marshal(ociManifest{Config: descriptor{Digest: configDigest}, Layers: []descriptor{{Digest: layerDigest}}})
+				require.NoError(t, err)
+				manifestDigest := writeBlob(t, dir, manifest)
+				nested := ociIndex{Manifests: []descriptor{{Digest: manifestDigest}}}
+				nested.Manifests[0].Platform = &struct {
+					Architecture string `json:"architecture"`
+					OS           string `json:"os"`
+				}{Architecture: "amd64", OS: "linux"}
+				content, err := json.Marshal(nested)
+				require.NoError(t, err)
+				indexDigest := writeBlob(t, dir, content)
+				index, err := json.Marshal(ociIndex{Manifests: []descriptor{{MediaType: mediaTypeOCIIndex, Digest: indexDigest}}})
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ociIndexFile), index, 0o600))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ociLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o600))
+				return []string{indexDigest, manifestDigest, configDigest}
+			},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			digests := testCase.layout(t, dir)
+			result, err := Baseline(dir, []string{"etc/nginx"}, "SHA256", "linux/amd64", api.SymlinksWithin, nil)
+			require.NoError(t, err)
+			assert.Equal(t, digests, result.Digests)
+
+			repository, err := repositories.NewBoltRepository(filepath.Join(t.TempDir(), "test.db"), logrus.New())
+			require.NoError(t, err)
+			defer repository.Close()
+			for _, digest := range result.Digests {
+				require.NoError(t, repository.SaveImageBaseline(digest, "nginx:latest", result.HashData))
+			}
+
+			// The sidecar looks the baseline up by the digest in the image ID of the container status
+			imageID := fmt.Sprintf(testCase.imageID, digests[0])
+			baseline, err := repository.GetImageBaseline(DigestFromImageID(imageID), "SHA256")
+			require.NoError(t, err)
+			require.Len(t, baseline, 1)
+			assert.Equal(t, "/etc/nginx/nginx.conf", baseline[0].FullFilePath)
+			assert.Equal(t, sum("config"), baseline[0].Hash)
+		})
+	}
+}
+
+func TestDigestFromImageID(t *testing.T) {
+	assert.Equal(t, "sha256:aaa", DigestFromImageID("docker.io/library/nginx@sha256:aaa"))
+	assert.Equal(t, "sha256:aaa", DigestFromImageID("docker-pullable://nginx@sha256:aaa"))
+	assert.Equal(t, "sha256:bbb", DigestFromImageID("docker://sha256:bbb"))
+	assert.Equal(t, "sha256:bbb", DigestFromImageID("sha256:bbb"))
+}
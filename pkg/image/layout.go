@@ -0,0 +1,269 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	mediaTypeOCIIndex      = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList    = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociLayoutFile          = "oci-layout"
+	ociIndexFile           = "index.json"
+	dockerManifestFile     = "manifest.json"
+	defaultPlatformOS      = "linux"
+	maxNestedIndexes       = 4
+	extractedDirNamePrefix = "integrity-sum-image-"
+)
+
+// descriptor is the part of an OCI content descriptor used to find manifests and layers
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+type ociIndex struct {
+	Manifests []descriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+type dockerManifest struct {
+	Config      string   `json:"Config"`
+	RepoDigests []string `json:"RepoDigests"`
+	Layers      []string `json:"Layers"`
+}
+
+// layout is an unpacked image: the digests identifying it and the paths to the layer blobs from the lowest to the topmost
+type layout struct {
+	digests []string
+	layers  []string
+}
+
+// addDigest appends a digest which is not known yet
+func (l *layout) addDigest(digest string) {
+	if digest == "" {
+		return
+	}
+	for _, known := range l.digests {
+		if known == digest {
+			return
+		}
+	}
+	l.digests = append(l.digests, digest)
+}
+
+// DigestFromImageID extracts the digest from the image ID reported in the status of a container,
+// like docker.io/library/nginx@sha256:..., docker-pullable://nginx@sha256:... or docker://sha256:...
+// A pulled image is reported with its repository digest, an image built on the node with its config digest
+func DigestFromImageID(imageID string) string {
+	if i := strings.LastIndex(imageID, "@"); i >= 0 {
+		return imageID[i+1:]
+	}
+	if i := strings.Index(imageID, "://"); i >= 0 {
+		return imageID[i+3:]
+	}
+	return imageID
+}
+
+// openLayout reads an OCI image layout directory or a `docker save` tarball.
+// Tarballs are unpacked into a temporary directory which is removed by the returned cleanup function
+func openLayout(imagePath, platform string) (*layout, func(), error) {
+	cleanup := func() {}
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	dir := imagePath
+	if !info.IsDir() {
+		dir, err = os.MkdirTemp("", extractedDirNamePrefix)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+		if err := extractTar(imagePath, dir); err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+	}
+
+	var l *layout
+	switch {
+	case fileExists(filepath.Join(dir, dockerManifestFile)):
+		l, err = readDockerLayout(dir)
+	case fileExists(filepath.Join(dir, ociIndexFile)) && fileExists(filepath.Join(dir, ociLayoutFile)):
+		l, err = readOCILayout(dir, platform)
+	default:
+		err = fmt.Errorf("%s is neither an OCI image layout nor a docker save archive", imagePath)
+	}
+	if err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	return l, cleanup, nil
+}
+
+// readDockerLayout reads the manifest.json written by `docker save`. The image is identified by its repository digests,
+// by the digest of the manifest in index.json written by newer versions of docker and by its config digest
+func readDockerLayout(dir string) (*layout, error) {
+	var manifests []dockerManifest
+	if err := readJSON(filepath.Join(dir, dockerManifestFile), &manifests); err != nil {
+		return nil, err
+	}
+	if len(manifests) != 1 {
+		return nil, fmt.Errorf("archive contains %d images, expected exactly one", len(manifests))
+	}
+
+	manifest := manifests[0]
+	l := &layout{}
+	for _, repoDigest := range manifest.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+			l.addDigest(digest)
+		}
+	}
+	if fileExists(filepath.Join(dir, ociIndexFile)) {
+		var index ociIndex
+		if err := readJSON(filepath.Join(dir, ociIndexFile), &index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			l.addDigest(m.Digest)
+		}
+	}
+	configName := path.Base(manifest.Config)
+	l.addDigest("sha256:" + strings.TrimSuffix(configName, ".json"))
+	for _, layer := range manifest.Layers {
+		l.layers = append(l.layers, filepath.Join(dir, filepath.FromSlash(path.Clean("/"+layer))))
+	}
+	return l, nil
+}
+
+// readOCILayout resolves index.json to the manifest for the platform. The image is identified by the digest
+// of the top-level descriptor, which is what the container runtime reports for pulled images, by the digests
+// of the nested index and of the manifest for the platform, and by its config digest
+func readOCILayout(dir, platform string) (*layout, error) {
+	var index ociIndex
+	if err := readJSON(filepath.Join(dir, ociIndexFile), &index); err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, errors.New("index.json does not contain any manifest")
+	}
+	top := index.Manifests[0]
+	l := &layout{}
+	l.addDigest(top.Digest)
+
+	current := top
+	for i := 0; current.MediaType == mediaTypeOCIIndex || current.MediaType == mediaTypeDockerList; i++ {
+		if i == maxNestedIndexes {
+			return nil, errors.New("too many nested image indexes")
+		}
+		var nested ociIndex
+		if err := readJSON(blobPath(dir, current.Digest), &nested); err != nil {
+			return nil, err
+		}
+		found, err := selectPlatform(nested.Manifests, platform)
+		if err != nil {
+			return nil, err
+		}
+		current = found
+		l.addDigest(current.Digest)
+	}
+
+	var manifest ociManifest
+	if err := readJSON(blobPath(dir, current.Digest), &manifest); err != nil {
+		return nil, err
+	}
+	l.addDigest(manifest.Config.Digest)
+	for _, layer := range manifest.Layers {
+		l.layers = append(l.layers, blobPath(dir, layer.Digest))
+	}
+	return l, nil
+}
+
+// selectPlatform picks the manifest built for the platform written as os/architecture
+func selectPlatform(manifests []descriptor, platform string) (descriptor, error) {
+	wantOS, wantArch := defaultPlatformOS, platform
+	if parts := strings.SplitN(platform, "/", 2); len(parts) == 2 {
+		wantOS, wantArch = parts[0], parts[1]
+	}
+	for _, m := range manifests {
+		if m.Platform != nil && m.Platform.OS == wantOS && m.Platform.Architecture == wantArch {
+			return m, nil
+		}
+	}
+	return descriptor{}, fmt.Errorf("image has no manifest for platform %s/%s", wantOS, wantArch)
+}
+
+func blobPath(dir, digest string) string {
+	alg, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(dir, "blobs", alg, hex)
+}
+
+func readJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// extractTar unpacks the regular files of the archive, names are confined to the destination directory
+func extractTar(archive, dest string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.Join(dest, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+		if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
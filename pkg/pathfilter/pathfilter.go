@@ -0,0 +1,174 @@
+package pathfilter
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rule is one gitignore-style pattern split into path segments
+type rule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	segments []string
+}
+
+// Filter selects the files to hash with gitignore-style patterns relative to the monitored directory.
+//
+// A pattern without a slash matches a file or directory name at any depth, a pattern with a slash
+// is anchored to the monitored directory, a trailing slash matches only directories, `**` matches
+// any number of directories and a leading `!` negates the pattern. The last matching pattern wins.
+// Patterns matching a directory match everything inside it.
+//
+// A file is hashed if it matches the include patterns (or there are none) and doesn't match the exclude patterns
+type Filter struct {
+	include []rule
+	exclude []rule
+}
+
+// New parses the include and exclude patterns, empty patterns and comments starting with # are skipped
+func New(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.include, err = parseRules(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseRules(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Split splits a comma-separated list of patterns as written in the ConfigMap or in a flag
+func Split(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Rel returns the path of name relative to root with slashes, as the patterns are written
+func Rel(root, name string) string {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return filepath.ToSlash(name)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func parseRules(patterns []string) ([]rule, error) {
+	var rules []rule
+	for _, pattern := range patterns {
+		r := rule{pattern: pattern}
+		p := strings.TrimSpace(pattern)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		if strings.HasPrefix(p, "!") {
+			r.negate = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			r.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		anchored := strings.Contains(p, "/")
+		p = strings.TrimPrefix(p, "/")
+		if p == "" {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		r.segments = strings.Split(p, "/")
+		if !anchored {
+			r.segments = append([]string{"**"}, r.segments...)
+		}
+		for _, segment := range r.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// IsEmpty reports whether the filter selects every file
+func (f *Filter) IsEmpty() bool {
+	return f == nil || (len(f.include) == 0 && len(f.exclude) == 0)
+}
+
+// SkipDir reports whether the directory is excluded, so the walk doesn't descend into it.
+// The path is relative to the monitored directory and uses slashes
+func (f *Filter) SkipDir(rel string) bool {
+	if f == nil {
+		return false
+	}
+	return matchTree(f.exclude, rel, true)
+}
+
+// Match reports whether the file should be hashed, the path is relative to the monitored directory and uses slashes
+func (f *Filter) Match(rel string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchTree(f.include, rel, false) {
+		return false
+	}
+	return !matchTree(f.exclude, rel, false)
+}
+
+// matchTree reports whether the rules match the path or one of its parent directories
+func matchTree(rules []rule, rel string, isDir bool) bool {
+	if len(rules) == 0 || rel == "" || rel == "." {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if matchRules(rules, parts[:i], true) {
+			return true
+		}
+	}
+	return matchRules(rules, parts, isDir)
+}
+
+// matchRules returns the result of the last rule matching the path
+func matchRules(rules []rule, parts []string, isDir bool) bool {
+	matched := false
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(r.segments, parts) {
+			matched = !r.negate
+		}
+	}
+	return matched
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(parts) > 0
+			}
+			for i := 0; i < len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
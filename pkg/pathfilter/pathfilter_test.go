@@ -0,0 +1,62 @@
+package pathfilter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	testTable := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		path     string
+		expected bool
+	}{
+		{name: "no patterns", path: "nginx.conf", expected: true},
+		{name: "name at any depth", exclude: []string{"*.log"}, path: "logs/access.log", expected: false},
+		{name: "name does not match", exclude: []string{"*.log"}, path: "nginx.conf", expected: true},
+		{name: "anchored pattern", exclude: []string{"/cache"}, path: "cache/a", expected: false},
+		{name: "anchored pattern at depth", exclude: []string{"/cache"}, path: "sub/cache/a", expected: true},
+		{name: "directory only pattern", exclude: []string{"run/"}, path: "run/nginx.pid", expected: false},
+		{name: "directory only pattern on file", exclude: []string{"run/"}, path: "run", expected: true},
+		{name: "double star", exclude: []string{"conf.d/**/*.tmp"}, path: "conf.d/a/b/x.tmp", expected: false},
+		{name: "double star without directories", exclude: []string{"conf.d/**/*.tmp"}, path: "conf.d/x.tmp", expected: false},
+		{name: "negation", exclude: []string{"*.pid", "!keep.pid"}, path: "keep.pid", expected: true},
+		{name: "include", include: []string{"*.conf"}, path: "mime.types", expected: false},
+		{name: "include directory", include: []string{"conf.d/"}, path: "conf.d/default.conf", expected: true},
+		{name: "include and exclude", include: []string{"*.conf"}, exclude: []string{"temp.conf"}, path: "temp.conf", expected: false},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			f, err := New(testCase.include, testCase.exclude)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, f.Match(testCase.path))
+		})
+	}
+}
+
+func TestSkipDir(t *testing.T) {
+	f, err := New(nil, []string{"cache/", "*.log"})
+	require.NoError(t, err)
+	assert.True(t, f.SkipDir("cache"))
+	assert.True(t, f.SkipDir("a/cache"))
+	assert.False(t, f.SkipDir("conf.d"))
+	assert.False(t, f.SkipDir("."))
+
+	var empty *Filter
+	assert.False(t, empty.SkipDir("cache"))
+	assert.True(t, empty.Match("cache/a"))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, []string{"[a-"})
+	assert.Error(t, err)
+	_, err = New(nil, []string{"!/"})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"*.log", "cache/"}, Split(" *.log, ,cache/ "))
+}
@@ -0,0 +1,158 @@
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxCommLength is the length the kernel truncates the name of a process to in /proc/<pid>/comm
+	maxCommLength = 15
+	// statStartTime is the number of the field of /proc/<pid>/stat with the start time of the process
+	statStartTime = 22
+)
+
+var (
+	// ErrNoCriteria is returned when the matcher would select every process
+	ErrNoCriteria = errors.New("no process criteria set")
+
+	errMalformedStat = errors.New("malformed stat")
+)
+
+// Matcher selects processes, a process matches when it satisfies every criteria that is set
+type Matcher struct {
+	// Comm is the exact name of the executable as in /proc/<pid>/comm, longer names are truncated the same way as the kernel does
+	Comm string
+	// Cmdline is matched against the arguments of the process joined with spaces
+	Cmdline *regexp.Regexp
+	// ContainerID is searched for in /proc/<pid>/cgroup, a runtime prefix like containerd:// is ignored
+	ContainerID string
+}
+
+// Finder looks for processes in a proc filesystem
+type Finder struct {
+	root string
+}
+
+// NewFinder creates a finder for the proc filesystem mounted at root, usually /proc
+func NewFinder(root string) *Finder {
+	return &Finder{root: root}
+}
+
+// Root returns the path of the root filesystem of the process as seen through the proc filesystem
+func (f *Finder) Root(pid int) string {
+	return filepath.Join(f.root, strconv.Itoa(pid), "root")
+}
+
+// Find returns the PIDs of all processes matching m, the process started first comes first and
+// processes started in the same clock tick are ordered by PID. PIDs are reused, so a lower one may
+// belong to a newer process. Entries which are not processes and processes which exit while they are read are skipped
+func (f *Finder) Find(m Matcher) ([]int, error) {
+	if m.Comm == "" && m.Cmdline == nil && m.ContainerID == "" {
+		return nil, ErrNoCriteria
+	}
+	entries, err := os.ReadDir(f.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	startTimes := make(map[int]uint64)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		ok, err := f.match(pid, m)
+		if err != nil || !ok {
+			continue
+		}
+		startTime, err := f.startTime(pid)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+		startTimes[pid] = startTime
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		if startTimes[pids[i]] != startTimes[pids[j]] {
+			return startTimes[pids[i]] < startTimes[pids[j]]
+		}
+		return pids[i] < pids[j]
+	})
+	return pids, nil
+}
+
+// startTime returns the time the process started after the boot in clock ticks, the field 22 of /proc/<pid>/stat
+func (f *Finder) startTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(f.root, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The name in the second field may contain spaces and parentheses, the fields after it start with the state
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("%w of the process %d", errMalformedStat, pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < statStartTime-2 {
+		return 0, fmt.Errorf("%w of the process %d", errMalformedStat, pid)
+	}
+	return strconv.ParseUint(fields[statStartTime-3], 10, 64)
+}
+
+func (f *Finder) match(pid int, m Matcher) (bool, error) {
+	dir := filepath.Join(f.root, strconv.Itoa(pid))
+	if m.Comm != "" {
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			return false, err
+		}
+		if strings.TrimSuffix(string(comm), "\n") != truncateComm(m.Comm) {
+			return false, nil
+		}
+	}
+	if m.Cmdline != nil {
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			return false, err
+		}
+		args := strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+		// Kernel threads have an empty command line
+		if args == "" || !m.Cmdline.MatchString(args) {
+			return false, nil
+		}
+	}
+	if m.ContainerID != "" {
+		cgroup, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+		if err != nil {
+			return false, err
+		}
+		if !strings.Contains(string(cgroup), trimRuntime(m.ContainerID)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// trimRuntime removes the runtime prefix from a container ID as reported in the pod status, like containerd://<id>
+func trimRuntime(containerID string) string {
+	if i := strings.Index(containerID, "://"); i >= 0 {
+		return containerID[i+3:]
+	}
+	return containerID
+}
+
+func truncateComm(name string) string {
+	if len(name) > maxCommLength {
+		return name[:maxCommLength]
+	}
+	return name
+}
@@ -0,0 +1,84 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProcess struct {
+	pid     int
+	comm    string
+	cmdline string
+	cgroup  string
+	// startTime is the start time of the process in clock ticks
+	startTime uint64
+}
+
+func fakeProc(t *testing.T, processes []fakeProcess) string {
+	root := t.TempDir()
+	for _, p := range processes {
+		dir := filepath.Join(root, strconv.Itoa(p.pid))
+		require.NoError(t, os.MkdirAll(dir, 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "comm"), []byte(p.comm+"\n"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(p.cmdline), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup"), []byte(p.cgroup), 0o600))
+		// The fields between the name and the start time are not read
+		stat := fmt.Sprintf("%d (%s) S%s %d 0 0\n", p.pid, p.comm, strings.Repeat(" 0", statStartTime-4), p.startTime)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o600))
+	}
+	// Entries which are not processes
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpuinfo"), nil, 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "self"), 0o750))
+	// A process which exited while it was read
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "999"), 0o750))
+	return root
+}
+
+func TestFind(t *testing.T) {
+	root := fakeProc(t, []fakeProcess{
+		{pid: 1, comm: "pause", cmdline: "/pause\x00", cgroup: "0::/kubepods/pod1/cri-containerd-aaa111.scope\n", startTime: 100},
+		// A PID reused by a worker started after the master process
+		{pid: 3, comm: "nginx", cmdline: "nginx: worker process\x00", cgroup: "0::/kubepods/pod1/cri-containerd-bbb222.scope\n", startTime: 900},
+		{pid: 7, comm: "nginx", cmdline: "nginx: master process nginx -g daemon off;\x00", cgroup: "0::/kubepods/pod1/cri-containerd-bbb222.scope\n", startTime: 200},
+		{pid: 12, comm: "nginx", cmdline: "nginx: worker process\x00", cgroup: "0::/kubepods/pod1/cri-containerd-bbb222.scope\n", startTime: 200},
+		{pid: 20, comm: "nginx-exporter", cmdline: "/usr/bin/nginx-exporter\x00--nginx.scrape-uri=http://localhost/status\x00", cgroup: "0::/kubepods/pod1/cri-containerd-ccc333.scope\n", startTime: 300},
+		{pid: 31, comm: "php-fpm8.1-long", cmdline: "php-fpm: master process (/etc/php/8.1/fpm/php-fpm.conf)\x00", cgroup: "0::/kubepods/pod1/cri-containerd-ddd444.scope\n", startTime: 400},
+		{pid: 40, comm: "kworker/0:1", cgroup: "0::/\n", startTime: 10},
+		// The name in stat may contain parentheses and spaces
+		{pid: 50, comm: "a) 1 2 (b", cmdline: "a\x00", cgroup: "0::/\n", startTime: 500},
+	})
+	finder := NewFinder(root)
+
+	testTable := []struct {
+		name     string
+		matcher  Matcher
+		expected []int
+	}{
+		{name: "exact comm ordered by start time", matcher: Matcher{Comm: "nginx"}, expected: []int{7, 12, 3}},
+		{name: "comm is truncated by the kernel", matcher: Matcher{Comm: "php-fpm8.1-long-name"}, expected: []int{31}},
+		{name: "cmdline regex", matcher: Matcher{Cmdline: regexp.MustCompile(`^nginx: master`)}, expected: []int{7}},
+		{name: "kernel threads have no cmdline", matcher: Matcher{Cmdline: regexp.MustCompile(`.*`)}, expected: []int{1, 7, 12, 20, 31, 50, 3}},
+		{name: "container id", matcher: Matcher{ContainerID: "containerd://ccc333"}, expected: []int{20}},
+		{name: "all criteria", matcher: Matcher{Comm: "nginx", Cmdline: regexp.MustCompile(`worker`), ContainerID: "bbb222"}, expected: []int{12, 3}},
+		{name: "nothing matches", matcher: Matcher{Comm: "httpd"}},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			pids, err := finder.Find(testCase.matcher)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, pids)
+		})
+	}
+
+	_, err := finder.Find(Matcher{})
+	assert.ErrorIs(t, err, ErrNoCriteria)
+	assert.Equal(t, filepath.Join(root, "7", "root"), finder.Root(7))
+}
@@ -0,0 +1,88 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes the delays between attempts: they grow exponentially from Initial up to Max,
+// Jitter is the fraction of the delay randomly added or subtracted to spread the retries of many pods
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// DefaultBackoff is used for the database and Kubernetes API calls
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// permanentError stops the retries
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error that must not be retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether the error was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Delay returns the delay before the attempt following the given number of failed attempts
+func (b Backoff) Delay(failed int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 1; i < failed && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Do calls fn until it succeeds, returns a permanent error or the context is done.
+// onRetry is called before waiting for the next attempt
+func Do(ctx context.Context, b Backoff, fn func() error, onRetry func(failed int, err error, delay time.Duration)) error {
+	for failed := 1; ; failed++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if IsPermanent(err) {
+			return errors.Unwrap(err)
+		}
+
+		delay := b.Delay(failed)
+		if onRetry != nil {
+			onRetry(failed, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, b.Delay(1))
+	assert.Equal(t, 2*time.Second, b.Delay(2))
+	assert.Equal(t, 8*time.Second, b.Delay(4))
+	assert.Equal(t, 10*time.Second, b.Delay(10))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := b.Delay(2)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.LessOrEqual(t, delay, 3*time.Second)
+	}
+}
+
+func TestDo(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2}
+	errTransient := errors.New("transient")
+	errConfig := errors.New("config")
+
+	testTable := []struct {
+		name          string
+		errs          []error
+		expectedCalls int
+		expectedError error
+	}{
+		{name: "success after retries", errs: []error{errTransient, errTransient, nil}, expectedCalls: 3},
+		{name: "permanent error", errs: []error{errTransient, Permanent(errConfig)}, expectedCalls: 2, expectedError: errConfig},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			calls, retries := 0, 0
+			err := Do(context.Background(), b, func() error {
+				calls++
+				return testCase.errs[calls-1]
+			}, func(int, error, time.Duration) { retries++ })
+
+			assert.Equal(t, testCase.expectedError, err)
+			assert.Equal(t, testCase.expectedCalls, calls)
+			assert.Equal(t, testCase.expectedCalls-1, retries)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Do(ctx, b, func() error { return errTransient }, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,155 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/integrity-sum/pkg/pathfilter"
+	"github.com/sirupsen/logrus"
+)
+
+// Watcher watches a directory tree with inotify and reports the changed paths in batches.
+// Bursts of events are debounced: a batch is sent once no event arrived for the debounce interval,
+// or once the oldest pending event is older than the max delay
+type Watcher struct {
+	fs       *fsnotify.Watcher
+	root     string
+	filter   *pathfilter.Filter
+	debounce time.Duration
+	maxDelay time.Duration
+	logger   *logrus.Logger
+}
+
+// New creates a watcher for every directory under root which is not excluded by the filter
+func New(root string, filter *pathfilter.Filter, debounce, maxDelay time.Duration, logger *logrus.Logger) (*Watcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		fs:       fs,
+		root:     root,
+		filter:   filter,
+		debounce: debounce,
+		maxDelay: maxDelay,
+		logger:   logger,
+	}
+	if _, err := w.addTree(root); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.fs.Close()
+}
+
+// Run sends the batches of changed paths to the channel until the context is done, then closes the channel
+func (w *Watcher) Run(ctx context.Context, batches chan<- []string) error {
+	defer close(batches)
+
+	pending := make(map[string]struct{})
+	var firstEvent time.Time
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return nil
+			}
+			name := filepath.Clean(event.Name)
+			rel := pathfilter.Rel(w.root, name)
+			// Directory-only patterns apply to directories, a removed path is matched as a file like initialWalk does
+			if info, err := os.Lstat(name); err == nil && info.IsDir() && w.filter.SkipDir(rel) {
+				continue
+			}
+			if w.filter.Match(rel) {
+				pending[name] = struct{}{}
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				// Files may be created in a new directory before its watch is added
+				created, err := w.addTree(name)
+				if err != nil {
+					w.logger.Errorf("error while watching %s %s", name, err)
+				}
+				for _, path := range created {
+					pending[path] = struct{}{}
+				}
+			}
+
+			if firstEvent.IsZero() {
+				firstEvent = time.Now()
+			}
+			delay := w.debounce
+			if left := w.maxDelay - time.Since(firstEvent); left < delay {
+				delay = left
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(delay)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.Errorf("error while watching %s %s", w.root, err)
+		case <-timer.C:
+			batch := make([]string, 0, len(pending))
+			for path := range pending {
+				batch = append(batch, path)
+			}
+			sort.Strings(batch)
+			pending = make(map[string]struct{})
+			firstEvent = time.Time{}
+
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// addTree adds watches for the directory and all its subdirectories and returns the files and subdirectories found in them
+func (w *Watcher) addTree(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel := pathfilter.Rel(w.root, path)
+		if !info.IsDir() {
+			if w.filter.Match(rel) {
+				files = append(files, path)
+			}
+			return nil
+		}
+		if path != w.root && w.filter.SkipDir(rel) {
+			return filepath.SkipDir
+		}
+		if path != root && w.filter.Match(rel) {
+			files = append(files, path)
+		}
+		return w.fs.Add(path)
+	})
+	return files, err
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/integrity-sum/pkg/pathfilter"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcherCoalescesEvents(t *testing.T) {
+	root := t.TempDir()
+	w, err := New(root, nil, 100*time.Millisecond, time.Second, logrus.New())
+	require.NoError(t, err)
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := make(chan []string)
+	go w.Run(ctx, batches)
+
+	subDir := filepath.Join(root, "conf.d")
+	require.NoError(t, os.Mkdir(subDir, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.conf"), []byte("a"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.conf"), []byte("b"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(subDir, "b.conf"), []byte("b"), 0o600))
+
+	seen := make(map[string]struct{})
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 3 {
+		select {
+		case batch := <-batches:
+			for _, path := range batch {
+				seen[path] = struct{}{}
+			}
+		case <-timeout:
+			t.Fatalf("changed paths were not reported, got %v", seen)
+		}
+	}
+	assert.Contains(t, seen, filepath.Join(root, "a.conf"))
+	assert.Contains(t, seen, subDir)
+	assert.Contains(t, seen, filepath.Join(subDir, "b.conf"))
+}
+
+func TestWatcherDirectoryOnlyExclude(t *testing.T) {
+	root := t.TempDir()
+	filter, err := pathfilter.New(nil, []string{"cache/"})
+	require.NoError(t, err)
+	w, err := New(root, filter, 100*time.Millisecond, time.Second, logrus.New())
+	require.NoError(t, err)
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := make(chan []string)
+	go w.Run(ctx, batches)
+
+	// A file named like an excluded directory is still reported, the files of the directory are not
+	require.NoError(t, os.Mkdir(filepath.Join(root, "sub"), 0o750))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "sub", "cache"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sub", "cache", "entry"), []byte("a"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cache"), []byte("a"), 0o600))
+
+	seen := make(map[string]struct{})
+	timeout := time.After(5 * time.Second)
+	for {
+		if _, ok := seen[filepath.Join(root, "cache")]; ok {
+			break
+		}
+		select {
+		case batch := <-batches:
+			for _, path := range batch {
+				seen[path] = struct{}{}
+			}
+		case <-timeout:
+			t.Fatalf("the file was not reported, got %v", seen)
+		}
+	}
+	assert.NotContains(t, seen, filepath.Join(root, "sub", "cache"))
+	assert.NotContains(t, seen, filepath.Join(root, "sub", "cache", "entry"))
+}